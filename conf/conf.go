@@ -0,0 +1,65 @@
+// Package conf holds copernicus's runtime configuration. Cfg is allocated
+// with its defaults by this package's init and every flag bound directly
+// to one of its fields, so main's flag.Parse() call fills Cfg in place
+// before bchMain reads any of it.
+package conf
+
+import "flag"
+
+// walletConfig is the -wallet* flag group.
+type walletConfig struct {
+	// Enable turns the built-in wallet on. Set with -wallet.
+	Enable bool
+	// Broadcast controls whether unconfirmed wallet transactions are
+	// periodically resubmitted to peers. Set with -walletbroadcast.
+	Broadcast bool
+	// LongTermFeeRate seeds wallet.Wallet's longTermFeeRate estimate of
+	// future spending cost (satoshis per KB), used by coin selection to
+	// decide whether a UTXO is worth adding at all. Set with
+	// -walletlongtermfeerate; 0 (the default) leaves the wallet's own
+	// built-in default in place.
+	LongTermFeeRate int64
+}
+
+// mempoolConfig is the mempool-policy flag group.
+type mempoolConfig struct {
+	// MinFeeRate is the minimum relay fee rate (satoshis per KB). Set
+	// with -minrelaytxfee.
+	MinFeeRate int64
+}
+
+// p2pNetConfig is the peer-to-peer networking flag group.
+type p2pNetConfig struct {
+	// DisableRPC skips starting the JSON-RPC server entirely. Set with
+	// -norpc.
+	DisableRPC bool
+}
+
+// Configuration is the root of every setting copernicus's subsystems read
+// through the package-level Cfg.
+type Configuration struct {
+	// DataDir is where chain and wallet state is persisted. Set with
+	// -datadir.
+	DataDir string
+
+	P2PNet  p2pNetConfig
+	Mempool mempoolConfig
+	Wallet  walletConfig
+}
+
+// Cfg is the active configuration. This package's init allocates it with
+// every flag's default; the command-line values land in it once main calls
+// flag.Parse.
+var Cfg *Configuration
+
+func init() {
+	cfg := &Configuration{}
+	flag.StringVar(&cfg.DataDir, "datadir", ".", "Directory to store data")
+	flag.BoolVar(&cfg.P2PNet.DisableRPC, "norpc", false, "Disable the built-in JSON-RPC server")
+	flag.Int64Var(&cfg.Mempool.MinFeeRate, "minrelaytxfee", 1000, "Minimum fee rate (satoshis per KB) for a transaction to be relayed")
+	flag.BoolVar(&cfg.Wallet.Enable, "wallet", false, "Enable the built-in wallet")
+	flag.BoolVar(&cfg.Wallet.Broadcast, "walletbroadcast", true, "Rebroadcast unconfirmed wallet transactions to peers")
+	flag.Int64Var(&cfg.Wallet.LongTermFeeRate, "walletlongtermfeerate", 0,
+		"Long-term fee rate (satoshis per KB) coin selection uses to decide whether spending a UTXO is worth it; 0 uses the wallet's built-in default")
+	Cfg = cfg
+}
@@ -0,0 +1,160 @@
+// Package lifecycle coordinates startup and shutdown of copernicus's
+// long-running subsystems (the p2p server, the RPC server, the wallet's
+// tx rebroadcaster, and so on). Each subsystem registers itself with a
+// Runner and declares what it depends on; the Runner starts dependencies
+// before dependents, waits for each one to report Ready before moving on,
+// and on shutdown stops everything in the reverse of the order it was
+// started, bounding each subsystem's teardown with StopTimeout.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/copernet/copernicus/log"
+)
+
+// StopTimeout bounds how long Stop waits for a single subsystem to tear
+// down before moving on to the next one in reverse-start order.
+const StopTimeout = 30 * time.Second
+
+// Subsystem is anything a Runner starts, waits on, and stops as a unit.
+// Start and Stop are each called at most once; Ready must not block past
+// the point Start has finished bringing the subsystem up far enough for
+// dependents to rely on it.
+type Subsystem interface {
+	// Name identifies the subsystem in logs and in other subsystems'
+	// DependsOn lists.
+	Name() string
+	// DependsOn lists the Names of subsystems that must be Ready before
+	// this one is started.
+	DependsOn() []string
+	// Start brings the subsystem up. It should return once startup has
+	// been kicked off; long-running work continues on ctx until Stop is
+	// called or ctx is canceled.
+	Start(ctx context.Context) error
+	// Ready is closed once the subsystem has finished starting.
+	Ready() <-chan struct{}
+	// Stop tears the subsystem down. ctx carries this subsystem's stop
+	// timeout; Stop should return promptly once ctx is done even if
+	// teardown hasn't finished.
+	Stop(ctx context.Context) error
+}
+
+// Runner starts a fixed set of Subsystems in dependency order and stops
+// them, on request, in the reverse of the order they were actually
+// started.
+type Runner struct {
+	subsystems map[string]Subsystem
+	started    []Subsystem
+}
+
+// NewRunner builds a Runner over subsystems. Names must be unique: a
+// subsystem whose Name repeats an earlier one's replaces it, with no error.
+// DependsOn edges must be acyclic; Start checks that each time it runs.
+func NewRunner(subsystems ...Subsystem) *Runner {
+	byName := make(map[string]Subsystem, len(subsystems))
+	for _, s := range subsystems {
+		byName[s.Name()] = s
+	}
+	return &Runner{subsystems: byName}
+}
+
+// Start topologically sorts the registered subsystems by DependsOn and
+// starts them in that order, waiting on each one's Ready before starting
+// anything that depends on it. ctx is handed to every Start call and to
+// the Ready-waits, so canceling it aborts an in-progress startup. If any
+// subsystem fails to start or become ready, Start stops everything
+// already started, in reverse order, before returning the error.
+func (r *Runner) Start(ctx context.Context) error {
+	order, err := r.topoSort()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range order {
+		log.Info("lifecycle: starting %s", s.Name())
+		if err := s.Start(ctx); err != nil {
+			r.Stop(context.Background())
+			return fmt.Errorf("lifecycle: starting %s: %w", s.Name(), err)
+		}
+		select {
+		case <-s.Ready():
+		case <-ctx.Done():
+			r.Stop(context.Background())
+			return fmt.Errorf("lifecycle: waiting for %s to become ready: %w", s.Name(), ctx.Err())
+		}
+		r.started = append(r.started, s)
+		log.Info("lifecycle: %s ready", s.Name())
+	}
+	return nil
+}
+
+// Stop tears down every started subsystem in the reverse of the order it
+// was started, giving each one StopTimeout to finish before moving on
+// regardless of whether it did.
+func (r *Runner) Stop(ctx context.Context) {
+	for i := len(r.started) - 1; i >= 0; i-- {
+		s := r.started[i]
+		stopCtx, cancel := context.WithTimeout(ctx, StopTimeout)
+		log.Info("lifecycle: stopping %s", s.Name())
+		if err := s.Stop(stopCtx); err != nil {
+			log.Error("lifecycle: stopping %s: %s", s.Name(), err.Error())
+		}
+		cancel()
+	}
+	r.started = nil
+}
+
+// topoSort orders subsystems so every dependency precedes its dependents,
+// erroring out on a dependency naming a subsystem that wasn't registered
+// or on a dependency cycle.
+func (r *Runner) topoSort() ([]Subsystem, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(r.subsystems))
+	order := make([]Subsystem, 0, len(r.subsystems))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("lifecycle: dependency cycle at %s", name)
+		}
+		s, ok := r.subsystems[name]
+		if !ok {
+			return fmt.Errorf("lifecycle: unknown dependency %s", name)
+		}
+		state[name] = visiting
+		for _, dep := range s.DependsOn() {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, s)
+		return nil
+	}
+
+	for name := range r.subsystems {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// ClosedReady returns an already-closed channel, for subsystems whose
+// Start brings them up synchronously and so are Ready the moment Start
+// returns.
+func ClosedReady() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
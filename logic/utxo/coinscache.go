@@ -1,22 +1,36 @@
 package utxo
 
 import (
-
-	"github.com/btcboost/copernicus/model/outpoint"
-	"github.com/btcboost/copernicus/model/utxo"
-	"github.com/btcboost/copernicus/util"
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/model/utxo"
+	"github.com/copernet/copernicus/util"
 )
 
+// AccessByTxid returns the first unspent output of the transaction hash, or
+// nil if it has none left. Lookup goes through CoinsCache's secondary txid
+// index instead of probing output indices 0..10999, so it's correct for
+// transactions with more than 11000 outputs and doesn't cost an index scan
+// per call.
+func AccessByTxid(coinsCache *utxo.CoinsCache, hash *util.Hash) *utxo.Coin {
+	coins := AccessAllByTxid(coinsCache, hash)
+	if len(coins) == 0 {
+		return nil
+	}
+	return coins[0]
+}
 
+// AccessAllByTxid returns every still-unspent output of the transaction
+// hash, in ascending output-index order, rather than just the first one
+// AccessByTxid returns.
+func AccessAllByTxid(coinsCache *utxo.CoinsCache, hash *util.Hash) []*utxo.Coin {
+	indices := coinsCache.LiveOutputIndices(hash)
 
-func AccessByTxid(coinsCache *utxo.CoinsCache, hash *util.Hash) *utxo.Coin {
-	out := outpoint.OutPoint{ *hash,  0}
-	for int(out.Index) < 11000 { // todo modify to be precise
-		alternate,_ := coinsCache.GetCoin(&out)
-		if !alternate.IsSpent() {
-			return alternate
+	coins := make([]*utxo.Coin, 0, len(indices))
+	for _, index := range indices {
+		out := outpoint.OutPoint{*hash, index}
+		if coin, ok := coinsCache.GetCoin(&out); ok && !coin.IsSpent() {
+			coins = append(coins, coin)
 		}
-		out.Index++
 	}
-	return nil
-}
\ No newline at end of file
+	return coins
+}
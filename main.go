@@ -6,20 +6,22 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/debug"
-	"errors"
-	//"runtime/pprof"
+	"syscall"
 
-	"github.com/btcboost/copernicus/model/chainparams"
-	"github.com/btcboost/copernicus/net/limits"
-	"github.com/btcboost/copernicus/net/server"
-	"github.com/btcboost/copernicus/rpc"
-	"github.com/btcboost/copernicus/conf"
-	"github.com/btcboost/copernicus/service"
+	"github.com/copernet/copernicus/conf"
+	"github.com/copernet/copernicus/lifecycle"
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/model/chainparams"
+	"github.com/copernet/copernicus/net/limits"
+	"github.com/copernet/copernicus/net/server"
+	"github.com/copernet/copernicus/rpc"
 )
 
 const (
@@ -29,55 +31,70 @@ const (
 	blockDbNamePrefix = "blocks"
 )
 
-// btcdMain is the real main function for btcd.  It is necessary to work around
-// the fact that deferred functions do not run when os.Exit() is called.  The
-// optional serverChan parameter is mainly used by the service code to be
-// notified with the server once it is setup so it can gracefully stop it when
-// requested from the service control manager.
+// bchMain is the real main function for copernicus. It is necessary to
+// work around the fact that deferred functions do not run when os.Exit()
+// is called. ctx is canceled the moment an interrupt is requested (a
+// signal or the RPC server's "stop" command), which the lifecycle.Runner
+// propagates to every subsystem's Start.
 func bchMain(ctx context.Context) error {
-	// Load configuration and parse command line.  This function also
-	// initializes logging and configures it accordingly.
+	ctx, requestShutdown := context.WithCancel(ctx)
+	defer requestShutdown()
+	listenForInterrupts(requestShutdown)
 
-	interrupt := interruptListener()
-
-	s, err := server.NewServer(&chainparams.TestNet3Params, interrupt)
+	// server.NewServer still takes the old interrupt channel rather than a
+	// context - ctx.Done() satisfies that signature directly, so everything
+	// inside *server.Server that used to select on the old interruptListener()
+	// channel keeps working unchanged while the rest of bchMain moves to ctx.
+	srv, err := server.NewServer(&chainparams.TestNet3Params, ctx.Done())
 	if err != nil {
 		return err
 	}
-	//service2.NewMsgHandle(s.mh, )
-	var rpcServer *rpc.Server
+
+	subsystems := []lifecycle.Subsystem{
+		walletSubsystem{},
+		newMsgHandleSubsystem(srv),
+		newServerSubsystem(srv),
+		newRebroadcastSubsystem(srv),
+	}
 	if !conf.Cfg.P2PNet.DisableRPC {
-		rpcServer, err = rpc.InitRPCServer()
+		rpcServer, err := rpc.InitRPCServer()
 		if err != nil {
-			return errors.New("failed to init rpc")
+			return fmt.Errorf("failed to init rpc: %w", err)
 		}
-		// Start the rebroadcastHandler, which ensures user tx received by
-		// the RPC server are rebroadcast until being included in a block.
-		//go s.rebroadcastHandler()
-		rpcServer.Start()
+		subsystems = append(subsystems, newRPCSubsystem(rpcServer, requestShutdown))
 	}
 
-	service.NewMsgHandle(context.TODO(), s.PhCh, s)
-	if interruptRequested(interrupt) {
-		return nil
+	runner := lifecycle.NewRunner(subsystems...)
+	if err := runner.Start(ctx); err != nil {
+		return err
 	}
-	s.Start()
-	defer func() {
-		s.Stop()
-		// Shutdown the RPC server if it's not disabled.
-		if !conf.Cfg.P2PNet.DisableRPC {
-			rpcServer.Stop()
-		}
-	}()
+	defer runner.Stop(context.Background())
+
+	<-ctx.Done()
+	return nil
+}
+
+// listenForInterrupts spawns a goroutine that calls requestShutdown as
+// soon as an interrupt or termination signal arrives, then stops listening
+// so a second signal falls back to Go's default (immediate exit) instead
+// of being swallowed.
+func listenForInterrupts(requestShutdown func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<- rpcServer.RequestedProcessShutdown()
-		shutdownRequestChannel <- struct{}{}
+		sig := <-sigCh
+		signal.Stop(sigCh)
+		log.Info("received signal %s, shutting down", sig)
+		requestShutdown()
 	}()
-	<-interrupt
-	return nil
 }
 
 func main() {
+	// conf's flags are registered in its init(); parsing them here, before
+	// anything reads conf.Cfg, is what actually turns a flag like
+	// -walletlongtermfeerate into a value subsystems can see.
+	flag.Parse()
+
 	// Use all processor cores.
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
@@ -0,0 +1,56 @@
+package utxo
+
+import (
+	"github.com/copernet/copernicus/model/script"
+	"github.com/copernet/copernicus/model/txout"
+	"github.com/copernet/copernicus/util/amount"
+)
+
+// Coin is a single UTXO cache entry: a transaction output plus the
+// height/coinbase metadata needed for maturity checks, RPC verbose output,
+// and mempool validation.
+type Coin struct {
+	*txout.TxOut
+	height     int32
+	isCoinBase bool
+	spent      bool
+}
+
+// NewCoin wraps out as a cache entry created at height, marking it a
+// coinbase output when isCoinBase is true (coinbase outputs are immature
+// for COINBASE_MATURITY blocks).
+func NewCoin(out *txout.TxOut, height int32, isCoinBase bool) *Coin {
+	return &Coin{TxOut: out, height: height, isCoinBase: isCoinBase}
+}
+
+func (c *Coin) GetAmount() amount.Amount {
+	if c == nil {
+		return 0
+	}
+	return amount.Amount(c.GetValue())
+}
+
+func (c *Coin) GetScriptPubKey() *script.Script {
+	if c == nil {
+		return nil
+	}
+	return c.TxOut.GetScriptPubKey()
+}
+
+func (c *Coin) GetHeight() int32 {
+	if c == nil {
+		return 0
+	}
+	return c.height
+}
+
+func (c *Coin) IsCoinBase() bool {
+	return c != nil && c.isCoinBase
+}
+
+// IsSpent reports whether this coin has already been spent from the cache.
+// A nil Coin (output never existed, or was already evicted) also counts as
+// spent so callers can check it without a separate nil guard.
+func (c *Coin) IsSpent() bool {
+	return c == nil || c.spent
+}
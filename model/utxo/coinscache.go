@@ -0,0 +1,172 @@
+package utxo
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/model/script"
+	"github.com/copernet/copernicus/model/txout"
+	"github.com/copernet/copernicus/util"
+	"github.com/copernet/copernicus/util/amount"
+)
+
+// CoinsCache is an in-memory, write-back view over the UTXO set. It keeps
+// every cached coin plus a secondary txid->output-indices index so callers
+// can enumerate a transaction's outputs without scanning an arbitrary range
+// of indices.
+type CoinsCache struct {
+	mtx   sync.RWMutex
+	coins map[outpoint.OutPoint]*Coin
+
+	txidIndex *TxidIndex
+	db        *CoinsDB
+}
+
+// NewCoinsCache opens the UTXO DB, loads every persisted coin back into
+// memory, and loads the persisted txid index - rebuilding the index from
+// the now-populated coin set the first time it's missing (a fresh DB, or
+// an upgrade from a version that predates it).
+func NewCoinsCache() (*CoinsCache, error) {
+	db, err := InitCoinsDB()
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &CoinsCache{
+		coins:     make(map[outpoint.OutPoint]*Coin),
+		txidIndex: NewTxidIndex(db),
+		db:        db,
+	}
+
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+
+	if db.IsEmpty(bucketTxidIndex) {
+		cache.txidIndex.rebuildFrom(cache.coins)
+	} else if err := cache.txidIndex.load(); err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// load reads every coin persisted in bucketCoins back into the in-memory
+// cache. It must run before txidIndex.rebuildFrom, which only sees what's
+// already in cc.coins.
+func (cc *CoinsCache) load() error {
+	return cc.db.ForEach(bucketCoins, func(key, value []byte) error {
+		out, ok := decodeCoinKey(key)
+		if !ok {
+			return nil
+		}
+		coin := deserializeCoin(value)
+		if coin == nil {
+			return nil
+		}
+		cc.coins[out] = coin
+		return nil
+	})
+}
+
+// GetCoin returns the cached coin at out, or (nil, false) if it isn't
+// currently held by the cache.
+func (cc *CoinsCache) GetCoin(out *outpoint.OutPoint) (*Coin, bool) {
+	cc.mtx.RLock()
+	defer cc.mtx.RUnlock()
+
+	coin, ok := cc.coins[*out]
+	return coin, ok
+}
+
+// AddCoin records a newly-created output as a live coin, persists it to
+// bucketCoins, and adds it to the txid index.
+func (cc *CoinsCache) AddCoin(out *outpoint.OutPoint, coin *Coin) {
+	cc.mtx.Lock()
+	cc.coins[*out] = coin
+	cc.mtx.Unlock()
+
+	if err := cc.db.Put(bucketCoins, coinKey(out), serializeCoin(coin)); err != nil {
+		log.Error("CoinsCache persist coin fail for %s:%d. error:%s", out.Hash.String(), out.Index, err.Error())
+	}
+	cc.txidIndex.add(out.Hash, out.Index)
+}
+
+// SpendCoin marks out spent, removes it from bucketCoins, and removes it
+// from the txid index, so future AccessByTxid/AccessAllByTxid calls no
+// longer see it and a restart doesn't resurrect it.
+func (cc *CoinsCache) SpendCoin(out *outpoint.OutPoint) {
+	cc.mtx.Lock()
+	coin, ok := cc.coins[*out]
+	if ok {
+		coin.spent = true
+	}
+	cc.mtx.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := cc.db.Delete(bucketCoins, coinKey(out)); err != nil {
+		log.Error("CoinsCache delete coin fail for %s:%d. error:%s", out.Hash.String(), out.Index, err.Error())
+	}
+	cc.txidIndex.remove(out.Hash, out.Index)
+}
+
+// LiveOutputIndices returns the sorted indices of hash's still-unspent
+// outputs, per the secondary txid index.
+func (cc *CoinsCache) LiveOutputIndices(hash *util.Hash) []uint32 {
+	return cc.txidIndex.get(*hash)
+}
+
+// coinKey is the bucketCoins key for out: its txid followed by its output
+// index, big-endian. (TxidIndex.persist keys its own bucket by the bare
+// txid.)
+func coinKey(out *outpoint.OutPoint) []byte {
+	key := make([]byte, len(out.Hash)+4)
+	copy(key, out.Hash[:])
+	binary.BigEndian.PutUint32(key[len(out.Hash):], out.Index)
+	return key
+}
+
+// decodeCoinKey reverses coinKey, reporting false for anything that isn't
+// a well-formed hash+index key (so a corrupt or foreign entry is skipped
+// rather than panicking the load).
+func decodeCoinKey(key []byte) (outpoint.OutPoint, bool) {
+	var out outpoint.OutPoint
+	if len(key) != len(out.Hash)+4 {
+		return outpoint.OutPoint{}, false
+	}
+	copy(out.Hash[:], key[:len(out.Hash)])
+	out.Index = binary.BigEndian.Uint32(key[len(out.Hash):])
+	return out, true
+}
+
+// serializeCoin encodes coin as amount(8) | height(4) | isCoinBase(1) |
+// scriptPubKey, the same hand-rolled fixed-then-variable layout the wallet
+// uses for its own DB records (see saveHDIndices, loadWatchedKeys).
+func serializeCoin(coin *Coin) []byte {
+	pkScript := coin.GetScriptPubKey().GetData()
+	value := make([]byte, 8+4+1+len(pkScript))
+	binary.BigEndian.PutUint64(value[0:8], uint64(coin.GetAmount()))
+	binary.BigEndian.PutUint32(value[8:12], uint32(coin.GetHeight()))
+	if coin.IsCoinBase() {
+		value[12] = 1
+	}
+	copy(value[13:], pkScript)
+	return value
+}
+
+// deserializeCoin reverses serializeCoin, returning nil for a value too
+// short to have come from it.
+func deserializeCoin(value []byte) *Coin {
+	if len(value) < 13 {
+		return nil
+	}
+	amt := amount.Amount(binary.BigEndian.Uint64(value[0:8]))
+	height := int32(binary.BigEndian.Uint32(value[8:12]))
+	isCoinBase := value[12] == 1
+	pkScript := script.NewScriptRaw(append([]byte{}, value[13:]...))
+	return NewCoin(txout.NewTxOut(int64(amt), pkScript), height, isCoinBase)
+}
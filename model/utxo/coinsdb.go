@@ -0,0 +1,91 @@
+package utxo
+
+import (
+	"path/filepath"
+
+	"github.com/copernet/copernicus/conf"
+	"github.com/copernet/copernicus/log"
+	"github.com/etcd-io/bbolt"
+)
+
+var (
+	bucketCoins     = []byte("coins")
+	bucketTxidIndex = []byte("txidindex")
+)
+
+// CoinsDB is the on-disk UTXO store backing CoinsCache. It is a thin,
+// bucket-based key/value layer: CoinsCache owns the logic (what a coin is,
+// how the txid index is kept in sync), CoinsDB just persists bytes.
+type CoinsDB struct {
+	db *bbolt.DB
+}
+
+// InitCoinsDB opens (creating if necessary) the UTXO database and ensures
+// both its buckets exist.
+func InitCoinsDB() (*CoinsDB, error) {
+	dbPath := filepath.Join(conf.Cfg.DataDir, "chainstate")
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{bucketCoins, bucketTxidIndex} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CoinsDB{db: db}, nil
+}
+
+func (cdb *CoinsDB) Put(bucket, key, value []byte) error {
+	return cdb.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, value)
+	})
+}
+
+func (cdb *CoinsDB) Delete(bucket, key []byte) error {
+	return cdb.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+func (cdb *CoinsDB) Get(bucket, key []byte) ([]byte, error) {
+	var value []byte
+	err := cdb.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(bucket).Get(key)
+		if v != nil {
+			value = make([]byte, len(v))
+			copy(value, v)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (cdb *CoinsDB) ForEach(bucket []byte, fn func(key, value []byte) error) error {
+	return cdb.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(fn)
+	})
+}
+
+// IsEmpty reports whether bucket has no entries, used to decide whether the
+// txid index needs rebuilding on startup.
+func (cdb *CoinsDB) IsEmpty(bucket []byte) bool {
+	empty := true
+	err := cdb.db.View(func(tx *bbolt.Tx) error {
+		k, _ := tx.Bucket(bucket).Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	if err != nil {
+		log.Error("CoinsDB.IsEmpty(%s) fail. error:%s", bucket, err.Error())
+	}
+	return empty
+}
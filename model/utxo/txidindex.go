@@ -0,0 +1,136 @@
+package utxo
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/util"
+)
+
+// TxidIndex maps a txid to the sorted indices of its still-live (unspent)
+// outputs, kept in sync as CoinsCache.AddCoin/SpendCoin mutate the cache.
+// It replaces probing a fixed range of output indices with an O(log n)
+// lookup, and is persisted in its own bucket so it survives a restart
+// without needing a full rebuild.
+type TxidIndex struct {
+	mtx sync.RWMutex
+	idx map[util.Hash][]uint32
+	db  *CoinsDB
+}
+
+func NewTxidIndex(db *CoinsDB) *TxidIndex {
+	return &TxidIndex{idx: make(map[util.Hash][]uint32), db: db}
+}
+
+func (ti *TxidIndex) add(hash util.Hash, index uint32) {
+	ti.mtx.Lock()
+	defer ti.mtx.Unlock()
+
+	indices := ti.idx[hash]
+	pos := sort.Search(len(indices), func(i int) bool { return indices[i] >= index })
+	if pos < len(indices) && indices[pos] == index {
+		return
+	}
+	indices = append(indices, 0)
+	copy(indices[pos+1:], indices[pos:])
+	indices[pos] = index
+	ti.idx[hash] = indices
+
+	ti.persist(hash, indices)
+}
+
+func (ti *TxidIndex) remove(hash util.Hash, index uint32) {
+	ti.mtx.Lock()
+	defer ti.mtx.Unlock()
+
+	indices := ti.idx[hash]
+	pos := sort.Search(len(indices), func(i int) bool { return indices[i] >= index })
+	if pos == len(indices) || indices[pos] != index {
+		return
+	}
+	indices = append(indices[:pos], indices[pos+1:]...)
+
+	if len(indices) == 0 {
+		delete(ti.idx, hash)
+		if ti.db != nil {
+			if err := ti.db.Delete(bucketTxidIndex, hash[:]); err != nil {
+				log.Error("TxidIndex delete fail for %s. error:%s", hash.String(), err.Error())
+			}
+		}
+		return
+	}
+	ti.idx[hash] = indices
+	ti.persist(hash, indices)
+}
+
+func (ti *TxidIndex) get(hash util.Hash) []uint32 {
+	ti.mtx.RLock()
+	defer ti.mtx.RUnlock()
+
+	indices := ti.idx[hash]
+	out := make([]uint32, len(indices))
+	copy(out, indices)
+	return out
+}
+
+func (ti *TxidIndex) persist(hash util.Hash, indices []uint32) {
+	if ti.db == nil {
+		return
+	}
+	value := make([]byte, 4*len(indices))
+	for i, index := range indices {
+		binary.BigEndian.PutUint32(value[i*4:], index)
+	}
+	if err := ti.db.Put(bucketTxidIndex, hash[:], value); err != nil {
+		log.Error("TxidIndex persist fail for %s. error:%s", hash.String(), err.Error())
+	}
+}
+
+// load reads a previously-persisted txid index back from disk, used on
+// startup when the index bucket is already populated.
+func (ti *TxidIndex) load() error {
+	ti.mtx.Lock()
+	defer ti.mtx.Unlock()
+
+	return ti.db.ForEach(bucketTxidIndex, func(key, value []byte) error {
+		if len(key) != len(util.Hash{}) || len(value)%4 != 0 {
+			return nil
+		}
+		var hash util.Hash
+		copy(hash[:], key)
+
+		indices := make([]uint32, len(value)/4)
+		for i := range indices {
+			indices[i] = binary.BigEndian.Uint32(value[i*4:])
+		}
+		ti.idx[hash] = indices
+		return nil
+	})
+}
+
+// rebuildFrom recomputes the index from scratch by scanning every coin
+// currently held by the cache, then persists the result. It runs once at
+// startup whenever the on-disk index bucket is empty (fresh DB, or an
+// upgrade from a version that predates this index).
+func (ti *TxidIndex) rebuildFrom(coins map[outpoint.OutPoint]*Coin) {
+	ti.mtx.Lock()
+	defer ti.mtx.Unlock()
+
+	ti.idx = make(map[util.Hash][]uint32)
+	for out, coin := range coins {
+		if coin.IsSpent() {
+			continue
+		}
+		ti.idx[out.Hash] = append(ti.idx[out.Hash], out.Index)
+	}
+	for hash, indices := range ti.idx {
+		sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+		ti.idx[hash] = indices
+		ti.persist(hash, indices)
+	}
+
+	log.Info("rebuilt UTXO txid index: %d distinct txids", len(ti.idx))
+}
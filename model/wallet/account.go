@@ -0,0 +1,426 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/copernet/copernicus/crypto"
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/model/script"
+	"github.com/copernet/copernicus/util"
+	"github.com/copernet/copernicus/util/amount"
+)
+
+// AccountKind distinguishes a fully-owned account (holds private keys, can
+// sign) from a watch-only one (imported xpub / individual pubkeys only;
+// tracked and rescanned, but FundPSBT for it always returns unsigned).
+type AccountKind int
+
+const (
+	AccountSpending AccountKind = iota
+	AccountWatchOnly
+)
+
+func (k AccountKind) String() string {
+	if k == AccountWatchOnly {
+		return "watchonly"
+	}
+	return "spending"
+}
+
+var (
+	bucketWatchedKeys = []byte("watchedkeys")
+	bucketAccountMeta = []byte("accountmeta")
+)
+
+// Account is one named grouping of keys within the wallet - either a
+// spending BIP44 account derived from the wallet's own seed, or a
+// watch-only account tracking an externally-owned xpub or set of pubkeys.
+type Account struct {
+	Name string
+	Kind AccountKind
+}
+
+var errAccountExists = errors.New("wallet: account already exists")
+
+// CreateAccount opens a new spending account, deriving it as the next
+// unused BIP44 account index (m/44'/145'/index') below the wallet's master
+// seed.
+func (w *Wallet) CreateAccount(name string) (*Account, error) {
+	w.accountLock.Lock()
+	defer w.accountLock.Unlock()
+
+	if _, exists := w.hdAccounts[name]; exists {
+		return nil, errAccountExists
+	}
+
+	index := uint32(len(w.hdAccounts))
+	if err := w.openAccount(name, index); err != nil {
+		return nil, err
+	}
+	if err := w.saveAccountMeta(name, accountMeta{Kind: AccountSpending, BIP44Index: index}); err != nil {
+		log.Error("CreateAccount save meta fail. error:%s", err.Error())
+		return nil, err
+	}
+	return &Account{Name: name, Kind: AccountSpending}, nil
+}
+
+// ImportXPub registers name as a watch-only account rooted at the given
+// extended public key (e.g. "xpub6C...", already at the account level -
+// m/44'/145'/account'). The wallet derives external/internal chains from it
+// exactly like a spending account, but never holds private keys for them.
+//
+// originFingerprint and originPath describe, if the caller has them, the
+// external wallet's own master key fingerprint and the path from it down
+// to xpub (e.g. 44'/145'/0'). They're what a hardware signer/cosigner
+// needs to recognize a Bip32Derivation as its own; pass 0/nil if they
+// aren't available, and PSBTs for this account will carry only the
+// chain/index components this wallet can actually vouch for.
+func (w *Wallet) ImportXPub(name, xpub string, originFingerprint uint32, originPath []uint32) (*Account, error) {
+	w.accountLock.Lock()
+	defer w.accountLock.Unlock()
+
+	if _, exists := w.hdAccounts[name]; exists {
+		return nil, errAccountExists
+	}
+
+	accountKey, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("wallet: invalid xpub: %w", err)
+	}
+	if accountKey.IsPrivate() {
+		return nil, errors.New("wallet: refusing to import a private extended key as watch-only")
+	}
+
+	external, err := accountKey.Child(externalChain)
+	if err != nil {
+		return nil, err
+	}
+	internal, err := accountKey.Child(internalChain)
+	if err != nil {
+		return nil, err
+	}
+
+	nextExt, nextInt := w.loadHDIndices(name)
+	w.hdAccounts[name] = &hdAccount{
+		name:              name,
+		watchOnly:         true,
+		external:          external,
+		internal:          internal,
+		nextExternalIndex: nextExt,
+		nextInternalIndex: nextInt,
+		originFingerprint: originFingerprint,
+		originPath:        originPath,
+	}
+
+	meta := accountMeta{Kind: AccountWatchOnly, XPub: xpub, OriginFingerprint: originFingerprint, OriginPath: originPath}
+	if err := w.saveAccountMeta(name, meta); err != nil {
+		log.Error("ImportXPub save meta fail. error:%s", err.Error())
+		return nil, err
+	}
+	return &Account{Name: name, Kind: AccountWatchOnly}, nil
+}
+
+// ListAccounts returns every account the wallet knows about, sorted by
+// name for stable RPC output.
+func (w *Wallet) ListAccounts() []*Account {
+	w.accountLock.RLock()
+	defer w.accountLock.RUnlock()
+
+	accounts := make([]*Account, 0, len(w.hdAccounts))
+	for name, acct := range w.hdAccounts {
+		kind := AccountSpending
+		if acct.watchOnly {
+			kind = AccountWatchOnly
+		}
+		accounts = append(accounts, &Account{Name: name, Kind: kind})
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts
+}
+
+// GetAccountBalance sums the value of every unspent, unlocked output that
+// pays a key or watched pubkey belonging to account name, across every
+// trusted wallet transaction.
+func (w *Wallet) GetAccountBalance(name string) (amount.Amount, error) {
+	w.accountLock.RLock()
+	acct, ok := w.hdAccounts[name]
+	w.accountLock.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("wallet: no such account %q", name)
+	}
+
+	balance := amount.Amount(0)
+	w.txnLock.RLock()
+	defer w.txnLock.RUnlock()
+	for hash, walletTx := range w.walletTxns {
+		if !w.IsTrusted(walletTx) {
+			continue
+		}
+		for index := 0; index < walletTx.Tx.GetOutsCount(); index++ {
+			out := outpoint.NewOutPoint(hash, uint32(index))
+			if _, locked := w.lockedCoins[*out]; locked {
+				continue
+			}
+			coin := walletTx.GetUnspentCoin(index)
+			if coin == nil || coin.IsSpent() {
+				continue
+			}
+			if !w.scriptBelongsToAccount(coin.GetScriptPubKey(), acct) {
+				continue
+			}
+			balance += amount.Amount(coin.GetAmount())
+		}
+	}
+	return balance, nil
+}
+
+// scriptBelongsToAccount reports whether scriptPubKey resolves to a key
+// this specific account owns (spending) or watches (watch-only), as
+// opposed to just any account in the wallet.
+func (w *Wallet) scriptBelongsToAccount(scriptPubKey *script.Script, acct *hdAccount) bool {
+	pubKeyType, pubKeys, ok := resolveSpendableScript(scriptPubKey)
+	if !ok {
+		return false
+	}
+
+	var candidates [][]byte
+	switch pubKeyType {
+	case script.ScriptPubkey:
+		candidates = [][]byte{util.Hash160(pubKeys[0])}
+	case script.ScriptPubkeyHash:
+		candidates = [][]byte{pubKeys[0]}
+	case script.ScriptMultiSig:
+		for _, pubKey := range pubKeys[1:] {
+			candidates = append(candidates, util.Hash160(pubKey))
+		}
+	default:
+		return false
+	}
+
+	for _, pubKeyHash := range candidates {
+		if w.pubKeyHashInAccount(pubKeyHash, acct) {
+			return true
+		}
+	}
+	return false
+}
+
+// pubKeyHashInAccount walks acct's already-derived external/internal
+// indices looking for pubKeyHash. It mirrors searchAccountChain in psbt.go
+// but scoped to a single account rather than searched across all of them.
+func (w *Wallet) pubKeyHashInAccount(pubKeyHash []byte, acct *hdAccount) bool {
+	for _, chainID := range []uint32{externalChain, internalChain} {
+		limit := acct.nextExternalIndex
+		parent := acct.external
+		if chainID == internalChain {
+			limit = acct.nextInternalIndex
+			parent = acct.internal
+		}
+		for index := uint32(0); index < limit; index++ {
+			child, err := parent.Child(index)
+			if err != nil {
+				continue
+			}
+			ecPub, err := child.ECPubKey()
+			if err != nil {
+				continue
+			}
+			if bytesEqual(util.Hash160(ecPub.SerializeCompressed()), pubKeyHash) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func bytesEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// --- watch-only key tracking -------------------------------------------
+
+// watchedKey is what the wallet remembers about a pubkey it tracks but
+// holds no private key for: which watch-only account it belongs to, and
+// the pubkey itself (needed to attach a Bip32Derivation to a PSBT, since a
+// P2PKH scriptPubKey only ever carries the pubkey *hash*).
+type watchedKey struct {
+	accountName string
+	pubKey      *crypto.PublicKey
+}
+
+var watchedKeysLock sync.RWMutex
+
+// watchPubKey records pubKey as belonging to the given watch-only account,
+// both in memory (for IsWatched) and on disk (so it survives a restart).
+func (w *Wallet) watchPubKey(accountName string, pubKey *crypto.PublicKey) {
+	pubKeyBytes := pubKey.ToBytes()
+	pubKeyHash := util.Hash160(pubKeyBytes)
+
+	watchedKeysLock.Lock()
+	w.watchedKeys[string(pubKeyHash)] = &watchedKey{accountName: accountName, pubKey: pubKey}
+	watchedKeysLock.Unlock()
+
+	value := append(append([]byte{}, []byte(accountName)...), pubKeyBytes...)
+	if err := w.WalletDB.Put(bucketWatchedKeys, pubKeyHash, value); err != nil {
+		log.Error("watchPubKey save to db fail. error:%s", err.Error())
+	}
+}
+
+// isWatchedPubKeyHash reports whether pubKeyHash was registered by any
+// watch-only account's ImportXPub-derived keypool.
+func (w *Wallet) isWatchedPubKeyHash(pubKeyHash []byte) bool {
+	watchedKeysLock.RLock()
+	defer watchedKeysLock.RUnlock()
+	_, ok := w.watchedKeys[string(pubKeyHash)]
+	return ok
+}
+
+// watchedPubKey returns the tracked public key for pubKeyHash, or nil if
+// it isn't watched. PSBT construction uses this to attach derivation
+// metadata for watch-only accounts, which hold no KeyStore entry to
+// recover the pubkey from.
+func (w *Wallet) watchedPubKey(pubKeyHash []byte) *crypto.PublicKey {
+	watchedKeysLock.RLock()
+	defer watchedKeysLock.RUnlock()
+	entry, ok := w.watchedKeys[string(pubKeyHash)]
+	if !ok {
+		return nil
+	}
+	return entry.pubKey
+}
+
+func (w *Wallet) loadWatchedKeys() {
+	w.watchedKeys = make(map[string]*watchedKey)
+	w.WalletDB.ForEach(bucketWatchedKeys, func(key, value []byte) error {
+		if len(value) <= 0 {
+			return nil
+		}
+		// accountName is variable-length and pubKeyBytes is fixed at 33
+		// bytes (compressed), so split from the end.
+		if len(value) < 33 {
+			return nil
+		}
+		split := len(value) - 33
+		accountName := string(value[:split])
+		pubKey := crypto.NewPublicKey(value[split:])
+		w.watchedKeys[string(key)] = &watchedKey{accountName: accountName, pubKey: pubKey}
+		return nil
+	})
+}
+
+// accountMeta is the small on-disk record CreateAccount/ImportXPub persist
+// so their accounts get re-opened the next time the wallet starts up.
+type accountMeta struct {
+	Kind       AccountKind
+	BIP44Index uint32
+	XPub       string
+
+	// OriginFingerprint/OriginPath are only meaningful for Kind ==
+	// AccountWatchOnly; see ImportXPub.
+	OriginFingerprint uint32
+	OriginPath        []uint32
+}
+
+func (w *Wallet) saveAccountMeta(name string, meta accountMeta) error {
+	path := make([]string, len(meta.OriginPath))
+	for i, step := range meta.OriginPath {
+		path[i] = strconv.FormatUint(uint64(step), 10)
+	}
+	value := []byte(fmt.Sprintf("%d:%d:%d:%s:%s",
+		meta.Kind, meta.BIP44Index, meta.OriginFingerprint, strings.Join(path, ","), meta.XPub))
+	return w.WalletDB.Put(bucketAccountMeta, []byte(name), value)
+}
+
+// parseOriginPath parses the comma-separated derivation steps
+// saveAccountMeta encodes OriginPath as, returning nil for the empty
+// string (an account imported with no known origin path).
+func parseOriginPath(csv string) ([]uint32, error) {
+	if csv == "" {
+		return nil, nil
+	}
+	fields := strings.Split(csv, ",")
+	path := make([]uint32, len(fields))
+	for i, field := range fields {
+		step, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		path[i] = uint32(step)
+	}
+	return path, nil
+}
+
+// loadAccounts re-opens every non-default account (spending or watch-only)
+// recorded by a prior CreateAccount/ImportXPub call. It must run after
+// initHDWallet, since spending accounts derive from the wallet's master
+// seed.
+func (w *Wallet) loadAccounts() error {
+	var loadErr error
+	w.WalletDB.ForEach(bucketAccountMeta, func(key, value []byte) error {
+		name := string(key)
+		if name == DefaultAccountName {
+			return nil
+		}
+
+		// XPub is empty for spending accounts, so this can't use
+		// fmt.Sscanf's "%s" (it errors on nothing left to scan) - split
+		// on ":" instead, capping at 5 fields so an xpub itself never
+		// gets split on.
+		fields := strings.SplitN(string(value), ":", 5)
+		if len(fields) != 5 {
+			log.Error("loadAccounts parse meta for %s fail: malformed record", name)
+			return nil
+		}
+		kindNum, err := strconv.Atoi(fields[0])
+		if err != nil {
+			log.Error("loadAccounts parse meta for %s fail. error:%s", name, err.Error())
+			return nil
+		}
+		bip44Index64, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			log.Error("loadAccounts parse meta for %s fail. error:%s", name, err.Error())
+			return nil
+		}
+		originFingerprint64, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			log.Error("loadAccounts parse meta for %s fail. error:%s", name, err.Error())
+			return nil
+		}
+		originPath, err := parseOriginPath(fields[3])
+		if err != nil {
+			log.Error("loadAccounts parse meta for %s fail. error:%s", name, err.Error())
+			return nil
+		}
+		kind := AccountKind(kindNum)
+		bip44Index := uint32(bip44Index64)
+		originFingerprint := uint32(originFingerprint64)
+		xpub := fields[4]
+
+		if kind == AccountWatchOnly {
+			if _, err := w.ImportXPub(name, xpub, originFingerprint, originPath); err != nil {
+				loadErr = err
+			}
+			return nil
+		}
+		if err := w.openAccount(name, bip44Index); err != nil {
+			loadErr = err
+		}
+		return nil
+	})
+	return loadErr
+}
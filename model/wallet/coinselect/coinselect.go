@@ -0,0 +1,160 @@
+// Package coinselect picks which of a wallet's unspent outputs to spend
+// when building an outgoing transaction: a Branch-and-Bound (BnB) search
+// for an exact, changeless match first, falling back to a simpler
+// knapsack/"single random draw" pass when no such match exists.
+package coinselect
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/util"
+	"github.com/copernet/copernicus/util/amount"
+)
+
+// ErrInsufficientFunds is returned when no combination of candidates,
+// exact or otherwise, covers the requested target.
+var ErrInsufficientFunds = errors.New("coinselect: insufficient funds")
+
+// maxBnBTries bounds how many branches the BnB search explores before
+// giving up and falling back to the knapsack selector. BnB is a depth-first
+// search over up to 2^n subsets in the worst case; this keeps selection a
+// bounded, predictable cost even with a large UTXO set.
+const maxBnBTries = 100000
+
+// Candidate is one spendable UTXO as coin selection sees it: its outpoint,
+// value, and the extra bytes it adds to the transaction once its scriptSig
+// is filled in (used to compute its effective value at a given fee rate).
+type Candidate struct {
+	OutPoint   *outpoint.OutPoint
+	Amount     amount.Amount
+	InputBytes int
+}
+
+// Result is the outcome of a successful selection: the inputs to spend,
+// their combined raw value (before subtracting fees), and whether BnB
+// found an exact match with no leftover change to pay for.
+type Result struct {
+	Selected   []*Candidate
+	Total      amount.Amount
+	ExactMatch bool
+}
+
+func sumAmount(candidates []*Candidate) amount.Amount {
+	total := amount.Amount(0)
+	for _, c := range candidates {
+		total += c.Amount
+	}
+	return total
+}
+
+// effectiveValue is a candidate's value minus what it costs to spend it at
+// feeRate - the number coin selection actually compares candidates by,
+// since a small UTXO with a disproportionately large scriptSig can cost
+// more to spend than it's worth.
+func effectiveValue(c *Candidate, feeRate *util.FeeRate) amount.Amount {
+	return c.Amount - amount.Amount(feeRate.GetFee(c.InputBytes))
+}
+
+// SelectCoins picks a subset of candidates covering target, honoring
+// feeRate for effective-value accounting and longTermFeeRate to discard
+// candidates that aren't worth spending even disregarding the current
+// target (their effective value at the long-term rate is negative). It
+// tries an exact Branch-and-Bound match within [target, target+costOfChange]
+// first, falling back to a knapsack/SRD pass that accepts leftover change
+// when no exact match exists.
+func SelectCoins(candidates []*Candidate, target amount.Amount, feeRate, longTermFeeRate *util.FeeRate, costOfChange amount.Amount) (*Result, error) {
+	usable := make([]*Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if effectiveValue(c, longTermFeeRate) < 0 {
+			// Costs more to spend, long-term, than it's worth - never
+			// economical to include regardless of what we're selecting for.
+			continue
+		}
+		usable = append(usable, c)
+	}
+
+	sort.Slice(usable, func(i, j int) bool {
+		return effectiveValue(usable[i], feeRate) > effectiveValue(usable[j], feeRate)
+	})
+
+	if result := selectBnB(usable, target, feeRate, costOfChange); result != nil {
+		return result, nil
+	}
+
+	return selectKnapsack(usable, target, feeRate)
+}
+
+// selectBnB depth-first searches usable (already sorted by descending
+// effective value) for a subset whose effective value sums to within
+// [target, target+costOfChange] - an exact match that needs no change
+// output at all. It prunes any branch whose best possible remaining sum
+// can't reach target, and any branch that has already overshot
+// target+costOfChange, and gives up after maxBnBTries.
+func selectBnB(usable []*Candidate, target amount.Amount, feeRate *util.FeeRate, costOfChange amount.Amount) *Result {
+	// remaining[i] is the sum of effective values of usable[i:], so a
+	// branch can be pruned the moment currentSum+remaining[i] < target.
+	remaining := make([]amount.Amount, len(usable)+1)
+	for i := len(usable) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + effectiveValue(usable[i], feeRate)
+	}
+
+	var best []*Candidate
+	tries := 0
+
+	var search func(index int, current []*Candidate, sum amount.Amount) bool
+	search = func(index int, current []*Candidate, sum amount.Amount) bool {
+		tries++
+		if tries > maxBnBTries {
+			return false
+		}
+
+		if sum > target+costOfChange {
+			return false // overshot; this branch can't recover
+		}
+		if sum >= target {
+			best = append([]*Candidate{}, current...)
+			return true // exact match in range - take the first one found
+		}
+		if index >= len(usable) || sum+remaining[index] < target {
+			return false // can't reach target even including everything left
+		}
+
+		// Try including usable[index] first (descending effective value,
+		// so the largest remaining candidate is tried first).
+		if search(index+1, append(current, usable[index]), sum+effectiveValue(usable[index], feeRate)) {
+			return true
+		}
+		// Then try excluding it.
+		return search(index+1, current, sum)
+	}
+
+	if !search(0, nil, 0) {
+		return nil
+	}
+	return &Result{Selected: best, Total: sumAmount(best), ExactMatch: true}
+}
+
+// selectKnapsack is the fallback when no exact BnB match exists: a single
+// random draw over usable (already filtered for long-term economics),
+// accumulating candidates in random order until target is covered. This
+// mirrors Bitcoin Core's SRD fallback - simpler than a true knapsack, but
+// avoids BnB's bias toward combining UTXOs into suspiciously round amounts.
+func selectKnapsack(usable []*Candidate, target amount.Amount, feeRate *util.FeeRate) (*Result, error) {
+	shuffled := append([]*Candidate{}, usable...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	var selected []*Candidate
+	effectiveTotal := amount.Amount(0)
+	for _, c := range shuffled {
+		selected = append(selected, c)
+		effectiveTotal += effectiveValue(c, feeRate)
+		if effectiveTotal >= target {
+			return &Result{Selected: selected, Total: sumAmount(selected), ExactMatch: false}, nil
+		}
+	}
+
+	return nil, ErrInsufficientFunds
+}
@@ -0,0 +1,78 @@
+package coinselect
+
+import (
+	"testing"
+
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/util"
+	"github.com/copernet/copernicus/util/amount"
+	"github.com/stretchr/testify/assert"
+)
+
+func candidate(index uint32, value amount.Amount) *Candidate {
+	return &Candidate{
+		OutPoint:   outpoint.NewOutPoint(util.Hash{byte(index), 1}, index),
+		Amount:     value,
+		InputBytes: 148,
+	}
+}
+
+func TestSelectCoinsBnBExactMatch(t *testing.T) {
+	candidates := []*Candidate{
+		candidate(0, 100000),
+		candidate(1, 50000),
+		candidate(2, 30000),
+	}
+	feeRate := util.NewFeeRate(0)
+	longTermFeeRate := util.NewFeeRate(0)
+
+	result, err := SelectCoins(candidates, 50000, feeRate, longTermFeeRate, 0)
+	assert.NoError(t, err)
+	assert.True(t, result.ExactMatch)
+	assert.Equal(t, amount.Amount(50000), result.Total)
+	assert.Len(t, result.Selected, 1)
+	assert.Equal(t, candidates[1].OutPoint, result.Selected[0].OutPoint)
+}
+
+func TestSelectCoinsFallsBackToKnapsack(t *testing.T) {
+	// No subset of these sums to anywhere near the target, so BnB can't
+	// find an exact match and selection must fall back to the knapsack
+	// pass, which is allowed to leave change.
+	candidates := []*Candidate{
+		candidate(0, 40000),
+		candidate(1, 45000),
+	}
+	feeRate := util.NewFeeRate(0)
+	longTermFeeRate := util.NewFeeRate(0)
+
+	result, err := SelectCoins(candidates, 70000, feeRate, longTermFeeRate, 0)
+	assert.NoError(t, err)
+	assert.False(t, result.ExactMatch)
+	assert.GreaterOrEqual(t, int64(result.Total), int64(70000))
+}
+
+func TestSelectCoinsDropsUneconomicalInputs(t *testing.T) {
+	// This candidate's value is smaller than what it costs to spend at
+	// longTermFeeRate, so it must never be selected even though it alone
+	// would cover the target at the current feeRate.
+	uneconomical := &Candidate{
+		OutPoint:   outpoint.NewOutPoint(util.Hash{9}, 0),
+		Amount:     1000,
+		InputBytes: 148,
+	}
+	candidates := []*Candidate{uneconomical}
+	feeRate := util.NewFeeRate(0)
+	longTermFeeRate := util.NewFeeRate(100000)
+
+	_, err := SelectCoins(candidates, 500, feeRate, longTermFeeRate, 0)
+	assert.Equal(t, ErrInsufficientFunds, err)
+}
+
+func TestSelectCoinsInsufficientFunds(t *testing.T) {
+	candidates := []*Candidate{candidate(0, 100)}
+	feeRate := util.NewFeeRate(0)
+	longTermFeeRate := util.NewFeeRate(0)
+
+	_, err := SelectCoins(candidates, 1000000, feeRate, longTermFeeRate, 0)
+	assert.Equal(t, ErrInsufficientFunds, err)
+}
@@ -0,0 +1,226 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/copernet/copernicus/crypto"
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/model/chain"
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/model/script"
+	"github.com/copernet/copernicus/model/tx"
+	"github.com/copernet/copernicus/model/txin"
+	"github.com/copernet/copernicus/model/txout"
+	"github.com/copernet/copernicus/model/utxo"
+	"github.com/copernet/copernicus/model/wallet/coinselect"
+	"github.com/copernet/copernicus/util"
+	"github.com/copernet/copernicus/util/amount"
+)
+
+// coinbaseMaturity is how many blocks must pass before a coinbase output
+// becomes spendable; immature coinbases are never offered to coin
+// selection.
+const coinbaseMaturity = 100
+
+// changeOutputBytes/inputBytesP2PKH are the size estimates coin selection
+// and fee calculation use throughout this file - the wallet only ever
+// produces P2PKH change and signs P2PKH inputs itself.
+const (
+	changeOutputBytes = 34
+	inputBytesP2PKH   = 148
+)
+
+// CreateTransaction builds, selects coins for, signs and records a
+// transaction paying recipients from changeAccount's trusted, mature,
+// unlocked coins, using Branch-and-Bound (falling back to knapsack/SRD)
+// coin selection. Unlike FundPSBT, this always signs and finalizes
+// immediately, so changeAccount must be a spending account - watch-only
+// accounts have no key to sign with and must go through FundPSBT instead.
+func (w *Wallet) CreateTransaction(recipients []*Recipient, feeRate *util.FeeRate, changeAccount string) (*tx.Tx, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("wallet: CreateTransaction requires at least one recipient")
+	}
+
+	w.accountLock.RLock()
+	acct, ok := w.hdAccounts[changeAccount]
+	w.accountLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("wallet: no such account %q", changeAccount)
+	}
+	if acct.watchOnly {
+		return nil, fmt.Errorf("wallet: account %q is watch-only, use FundPSBT instead of CreateTransaction", changeAccount)
+	}
+
+	recipientsTotal := amount.Amount(0)
+	for _, recipient := range recipients {
+		recipientsTotal += recipient.Value
+	}
+
+	candidates, coinByOutPoint := w.gatherSpendableCoins(acct)
+
+	// Coin selection needs a target that already includes the fee, but the
+	// fee depends on how many inputs get selected - so seed it with a
+	// rough estimate (assume two inputs plus a change output) and true it
+	// up against the actual input count once selection finishes.
+	roughSize := 2*inputBytesP2PKH + changeOutputBytes*(len(recipients)+1) + 10
+	roughFee := amount.Amount(feeRate.GetFee(roughSize))
+
+	costOfChange := amount.Amount(feeRate.GetFee(changeOutputBytes) + w.longTermFeeRate.GetFee(inputBytesP2PKH))
+
+	result, err := coinselect.SelectCoins(candidates, recipientsTotal+roughFee, feeRate, w.longTermFeeRate, costOfChange)
+	if err != nil {
+		return nil, fmt.Errorf("wallet: %w", err)
+	}
+
+	estimatedSize := inputBytesP2PKH*len(result.Selected) + changeOutputBytes*(len(recipients)+1) + 10
+	fee := amount.Amount(feeRate.GetFee(estimatedSize))
+	change := result.Total - recipientsTotal - fee
+	if change < 0 {
+		return nil, errors.New("wallet: insufficient funds to cover fee")
+	}
+
+	// Randomize input order so an observer can't infer which input was the
+	// "payer" from position alone.
+	selected := append([]*coinselect.Candidate{}, result.Selected...)
+	rand.Shuffle(len(selected), func(i, j int) { selected[i], selected[j] = selected[j], selected[i] })
+
+	unsignedTx := tx.NewTx(tx.DefaultVersion, 0)
+	for _, candidate := range selected {
+		unsignedTx.AddIn(txin.NewTxIn(candidate.OutPoint, script.NewEmptyScript(), script.SequenceFinal))
+	}
+
+	outs := make([]*txout.TxOut, 0, len(recipients)+1)
+	for _, recipient := range recipients {
+		outs = append(outs, txout.NewTxOut(int64(recipient.Value), recipient.ScriptPubKey))
+	}
+	if change > 0 {
+		changeKey, err := w.GetRawChangeKeyForAccount(changeAccount)
+		if err != nil {
+			return nil, err
+		}
+		changeScript := script.NewScriptRaw(script.NewP2PKHScript(util.Hash160(changeKey.ToBytes())))
+		outs = append(outs, txout.NewTxOut(int64(change), changeScript))
+	}
+	sortOutputsBIP69(outs)
+	for _, out := range outs {
+		unsignedTx.AddOut(out)
+	}
+
+	if err := w.signTransaction(unsignedTx, selected, coinByOutPoint); err != nil {
+		return nil, err
+	}
+
+	if err := w.AddToWallet(unsignedTx, map[string]string{"created": "CreateTransaction"}); err != nil {
+		log.Error("CreateTransaction save to wallet fail. error:%s", err.Error())
+		return nil, err
+	}
+	return unsignedTx, nil
+}
+
+// gatherSpendableCoins collects every trusted, mature, unlocked coin that
+// belongs to acct, alongside a lookup from outpoint back to the coin
+// (coinselect.Candidate only carries the outpoint, not the coin itself).
+//
+// Only P2PKH coins are offered as candidates: signTransaction only knows
+// how to sign that one script type, so a P2PK or bare-multisig coin
+// scriptBelongsToAccount also recognizes as ours would otherwise reach
+// coin selection, sometimes get picked, and then fail signing - turning
+// an ordinary CreateTransaction call into one that only succeeds
+// depending on which coins BnB/knapsack happen to choose.
+func (w *Wallet) gatherSpendableCoins(acct *hdAccount) ([]*coinselect.Candidate, map[outpoint.OutPoint]*utxo.Coin) {
+	var currentHeight int32
+	if tip := chain.GetInstance().Tip(); tip != nil {
+		currentHeight = tip.Height
+	}
+
+	w.txnLock.RLock()
+	defer w.txnLock.RUnlock()
+
+	var candidates []*coinselect.Candidate
+	coinByOutPoint := make(map[outpoint.OutPoint]*utxo.Coin)
+	for hash, wtx := range w.walletTxns {
+		if !w.IsTrusted(wtx) {
+			continue
+		}
+		for index := 0; index < wtx.Tx.GetOutsCount(); index++ {
+			out := outpoint.NewOutPoint(hash, uint32(index))
+			if _, locked := w.lockedCoins[*out]; locked {
+				continue
+			}
+			coin := wtx.GetUnspentCoin(index)
+			if coin == nil || coin.IsSpent() {
+				continue
+			}
+			if coin.IsCoinBase() && currentHeight-coin.GetHeight() < coinbaseMaturity {
+				continue
+			}
+			if !w.scriptBelongsToAccount(coin.GetScriptPubKey(), acct) {
+				continue
+			}
+			if pubKeyType, _, isStandard := coin.GetScriptPubKey().IsStandardScriptPubKey(); !isStandard || pubKeyType != script.ScriptPubkeyHash {
+				continue
+			}
+			candidates = append(candidates, &coinselect.Candidate{
+				OutPoint:   out,
+				Amount:     coin.GetAmount(),
+				InputBytes: inputBytesP2PKH,
+			})
+			coinByOutPoint[*out] = coin
+		}
+	}
+	return candidates, coinByOutPoint
+}
+
+// signTransaction fills in scriptSig = [sig] [pubkey] for every P2PKH input
+// selected, in the same order they were added to unsignedTx.
+func (w *Wallet) signTransaction(unsignedTx *tx.Tx, selected []*coinselect.Candidate, coinByOutPoint map[outpoint.OutPoint]*utxo.Coin) error {
+	sigHashType := uint32(crypto.SigHashAll | crypto.SigHashForkID)
+
+	for i, candidate := range selected {
+		coin := coinByOutPoint[*candidate.OutPoint]
+		pkScript := coin.GetScriptPubKey()
+
+		pubKeyType, pubKeys, isStandard := pkScript.IsStandardScriptPubKey()
+		if !isStandard || pubKeyType != script.ScriptPubkeyHash {
+			return fmt.Errorf("wallet: input %d is not a spendable P2PKH output", i)
+		}
+
+		keyPair := w.GetKeyPair(pubKeys[0])
+		if keyPair == nil {
+			return fmt.Errorf("wallet: missing key for input %d", i)
+		}
+
+		sigHash, err := tx.SignatureHash(unsignedTx, pkScript, sigHashType, i, int64(coin.GetAmount()))
+		if err != nil {
+			return err
+		}
+		sig, err := keyPair.Sign(sigHash)
+		if err != nil {
+			return err
+		}
+		sig = append(sig, byte(sigHashType))
+
+		var buf bytes.Buffer
+		pushScriptData(&buf, sig)
+		pushScriptData(&buf, keyPair.PubKey().ToBytes())
+		unsignedTx.GetIns()[i].SetScriptSig(script.NewScriptRaw(buf.Bytes()))
+	}
+	return nil
+}
+
+// sortOutputsBIP69 orders outputs by ascending value, then ascending
+// scriptPubKey bytes, so a transaction's output order reveals nothing about
+// which output was requested first - the same privacy rationale BIP69
+// applies to inputs and outputs alike.
+func sortOutputsBIP69(outs []*txout.TxOut) {
+	sort.Slice(outs, func(i, j int) bool {
+		if outs[i].GetValue() != outs[j].GetValue() {
+			return outs[i].GetValue() < outs[j].GetValue()
+		}
+		return bytes.Compare(outs[i].GetScriptPubKey().GetData(), outs[j].GetScriptPubKey().GetData()) < 0
+	})
+}
@@ -0,0 +1,334 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/copernet/copernicus/crypto"
+	"github.com/copernet/copernicus/log"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// BIP44 purpose/coin type used to derive copernicus wallet keys. 145 is the
+// SLIP-44 registered coin type for Bitcoin Cash.
+const (
+	hdPurpose  = 44
+	hdCoinType = 145
+
+	externalChain = uint32(0)
+	internalChain = uint32(1)
+)
+
+var hdNetParams = &chaincfg.MainNetParams
+
+var (
+	bucketHDSeed = []byte("hdseed")
+	keyHDSeed    = []byte("seed")
+)
+
+// hdAccount holds the two BIP32 chains ("m/44'/145'/account'/0" for receive
+// addresses, ".../1" for change) for one named wallet account, plus the
+// indices of the next key to hand out on each chain. Spending accounts
+// derive external/internal from the wallet's own master seed; watch-only
+// accounts derive them from an imported, already-neutered xpub and hold no
+// private keys at all.
+type hdAccount struct {
+	name      string
+	watchOnly bool
+	external  *hdkeychain.ExtendedKey
+	internal  *hdkeychain.ExtendedKey
+
+	// bip44Index is this account's index in m/44'/145'/index' for wallet-
+	// owned accounts; watch-only accounts imported from an external xpub
+	// have no such index and leave this at 0, unused (see originPath).
+	bip44Index uint32
+
+	// originFingerprint/originPath describe, for a watch-only account
+	// only, the *external* wallet's own master key and the path from it
+	// down to the imported xpub (e.g. 44'/145'/0'), as supplied to
+	// ImportXPub. They're 0/nil when that origin metadata wasn't
+	// supplied. Bip32Derivations for this account use these instead of
+	// this node's own masterKeyFingerprint/bip44Index, since the PSBT is
+	// headed back to the external wallet that needs to recognize its own
+	// key.
+	originFingerprint uint32
+	originPath        []uint32
+
+	// indexLock guards nextExternalIndex/nextInternalIndex so a derive,
+	// its index bump, and its DB persist happen as one critical section -
+	// without it, the keypool top-up ticker and a concurrent
+	// getnewaddress/getrawchangeaddress RPC could derive the same index
+	// twice and hand out the same address twice.
+	indexLock         sync.Mutex
+	nextExternalIndex uint32
+	nextInternalIndex uint32
+}
+
+// initHDWallet loads the wallet's master seed, generating and persisting a
+// fresh BIP39 mnemonic the first time the wallet is opened, then derives the
+// default account (account 0) external/internal chains.
+func (w *Wallet) initHDWallet() error {
+	seed, err := w.loadHDSeed()
+	if err != nil {
+		return err
+	}
+
+	if seed == nil {
+		entropy, err := bip39.NewEntropy(256)
+		if err != nil {
+			return err
+		}
+		mnemonic, err := bip39.NewMnemonic(entropy)
+		if err != nil {
+			return err
+		}
+		seed = bip39.NewSeed(mnemonic, "")
+		if err := w.saveHDSeed(seed); err != nil {
+			return err
+		}
+		if err := w.saveHDMnemonic(mnemonic); err != nil {
+			return err
+		}
+		log.Info("generated new HD wallet seed")
+	}
+
+	master, err := hdkeychain.NewMaster(seed, hdNetParams)
+	if err != nil {
+		return err
+	}
+	w.hdMaster = master
+
+	if err := w.openAccount(DefaultAccountName, 0); err != nil {
+		return err
+	}
+	w.activeAccount = DefaultAccountName
+	return nil
+}
+
+// DefaultAccountName is the account GenerateNewKey/GetReservedKey operate
+// against when the caller doesn't specify one - every wallet has it from
+// first run.
+const DefaultAccountName = "default"
+
+// openAccount derives the external/internal chains for BIP44 account index
+// bip44Index from the wallet's own master seed and registers it under name.
+func (w *Wallet) openAccount(name string, bip44Index uint32) error {
+	if w.hdMaster == nil {
+		return errors.New("wallet: HD master key not initialized")
+	}
+
+	purpose, err := w.hdMaster.Child(hdkeychain.HardenedKeyStart + hdPurpose)
+	if err != nil {
+		return err
+	}
+	coinType, err := purpose.Child(hdkeychain.HardenedKeyStart + hdCoinType)
+	if err != nil {
+		return err
+	}
+	account, err := coinType.Child(hdkeychain.HardenedKeyStart + bip44Index)
+	if err != nil {
+		return err
+	}
+	external, err := account.Child(externalChain)
+	if err != nil {
+		return err
+	}
+	internal, err := account.Child(internalChain)
+	if err != nil {
+		return err
+	}
+
+	nextExt, nextInt := w.loadHDIndices(name)
+	w.hdAccounts[name] = &hdAccount{
+		name:              name,
+		bip44Index:        bip44Index,
+		external:          external,
+		internal:          internal,
+		nextExternalIndex: nextExt,
+		nextInternalIndex: nextInt,
+	}
+	return nil
+}
+
+// deriveKey derives the key at chain/index below the account's external or
+// internal chain. For a spending account this adds the private key to the
+// wallet's key store; for a watch-only account (derived from an imported
+// xpub, so the chain only holds public material) it instead registers the
+// pubkey hash as watched, so IsWatched recognizes it without ever holding a
+// signing key.
+func (w *Wallet) deriveKey(acct *hdAccount, chain, index uint32) (*crypto.PublicKey, error) {
+	parent := acct.external
+	if chain == internalChain {
+		parent = acct.internal
+	}
+
+	child, err := parent.Child(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if acct.watchOnly {
+		ecPub, err := child.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		pubKey := crypto.NewPublicKey(ecPub.SerializeCompressed())
+		w.watchPubKey(acct.name, pubKey)
+		return pubKey, nil
+	}
+
+	ecPriv, err := child.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey := crypto.NewPrivateKeyFromBytes(ecPriv.Serialize(), true)
+	w.AddKey(privateKey)
+	return privateKey.PubKey(), nil
+}
+
+// GenerateNewKey derives and stores the next external-chain key for the
+// active account, replacing the old "random secret per call" behaviour.
+func (w *Wallet) GenerateNewKey() (*crypto.PublicKey, error) {
+	return w.GenerateNewKeyForAccount(w.activeAccount)
+}
+
+// GenerateNewKeyForAccount derives and stores the next external-chain key
+// for the named account (spending or watch-only).
+func (w *Wallet) GenerateNewKeyForAccount(accountName string) (*crypto.PublicKey, error) {
+	w.accountLock.RLock()
+	acct, ok := w.hdAccounts[accountName]
+	w.accountLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("wallet: no such account %q", accountName)
+	}
+
+	_, pubKey, err := w.deriveNextExternalKey(acct)
+	if err != nil {
+		log.Error("GenerateNewKey derive/save index fail. error:%s", err.Error())
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// deriveNextExternalKey derives acct's next external-chain key, advancing
+// and persisting its index as a single operation under acct.indexLock so
+// two callers (the keypool top-up ticker, a concurrent RPC) can never
+// derive or hand out the same index. It returns the index that was
+// consumed alongside the derived key, since callers like the keypool
+// track entries by index.
+func (w *Wallet) deriveNextExternalKey(acct *hdAccount) (uint32, *crypto.PublicKey, error) {
+	acct.indexLock.Lock()
+	defer acct.indexLock.Unlock()
+
+	index := acct.nextExternalIndex
+	pubKey, err := w.deriveKey(acct, externalChain, index)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	acct.nextExternalIndex = index + 1
+	if err := w.saveHDIndices(acct.name, acct.nextExternalIndex, acct.nextInternalIndex); err != nil {
+		return 0, nil, err
+	}
+	return index, pubKey, nil
+}
+
+// GetRawChangeKey derives the next internal (change) chain key for the
+// active account.
+func (w *Wallet) GetRawChangeKey() (*crypto.PublicKey, error) {
+	return w.GetRawChangeKeyForAccount(w.activeAccount)
+}
+
+// GetRawChangeKeyForAccount derives the next internal (change) chain key
+// for the named account. For a watch-only account this still succeeds -
+// it returns a public key with no corresponding private key in the wallet,
+// which is exactly what an unsigned PSBT's change output needs.
+func (w *Wallet) GetRawChangeKeyForAccount(accountName string) (*crypto.PublicKey, error) {
+	w.accountLock.RLock()
+	acct, ok := w.hdAccounts[accountName]
+	w.accountLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("wallet: no such account %q", accountName)
+	}
+
+	pubKey, err := w.deriveNextInternalKey(acct)
+	if err != nil {
+		log.Error("GetRawChangeKey derive/save index fail. error:%s", err.Error())
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// deriveNextInternalKey is deriveNextExternalKey's change-chain
+// counterpart; see its doc comment for why the derive+bump+persist
+// happens under acct.indexLock.
+func (w *Wallet) deriveNextInternalKey(acct *hdAccount) (*crypto.PublicKey, error) {
+	acct.indexLock.Lock()
+	defer acct.indexLock.Unlock()
+
+	index := acct.nextInternalIndex
+	pubKey, err := w.deriveKey(acct, internalChain, index)
+	if err != nil {
+		return nil, err
+	}
+
+	acct.nextInternalIndex = index + 1
+	if err := w.saveHDIndices(acct.name, acct.nextExternalIndex, acct.nextInternalIndex); err != nil {
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// DumpMnemonic returns the wallet's BIP39 mnemonic, backing the
+// `dumpwallet` RPC's seed-export mode.
+func (w *Wallet) DumpMnemonic() (string, error) {
+	seed, err := w.loadHDSeed()
+	if err != nil {
+		return "", err
+	}
+	if seed == nil {
+		return "", errors.New("wallet: no HD seed to dump")
+	}
+	// go-bip39 seeds are one-way (PBKDF2 of the mnemonic); copernicus keeps
+	// the original mnemonic alongside the seed so it can be re-exported.
+	mnemonic, err := w.loadHDMnemonic()
+	if err != nil {
+		return "", err
+	}
+	return mnemonic, nil
+}
+
+// saveHDSeed persists the wallet seed; saveHDMnemonic keeps the
+// human-readable mnemonic it was derived from for dumpwallet.
+//
+// TODO(wallet-encryption): these are stored in plaintext. There is no
+// passphrase or OS-keychain material anywhere in this wallet to derive a
+// real at-rest encryption key from, so encrypting with anything derivable
+// from the source (or the binary) would be security theater rather than
+// protection. Revisit once passphrase-based wallet encryption exists.
+func (w *Wallet) saveHDSeed(seed []byte) error {
+	return w.WalletDB.Put(bucketHDSeed, keyHDSeed, seed)
+}
+
+func (w *Wallet) loadHDSeed() ([]byte, error) {
+	return w.WalletDB.Get(bucketHDSeed, keyHDSeed)
+}
+
+func (w *Wallet) saveHDMnemonic(mnemonic string) error {
+	return w.WalletDB.Put(bucketHDSeed, []byte("mnemonic"), []byte(mnemonic))
+}
+
+func (w *Wallet) loadHDMnemonic() (string, error) {
+	mnemonic, err := w.WalletDB.Get(bucketHDSeed, []byte("mnemonic"))
+	if err != nil {
+		return "", err
+	}
+	if mnemonic == nil {
+		return "", errors.New("wallet: mnemonic not found, wallet may predate HD support")
+	}
+	return string(mnemonic), nil
+}
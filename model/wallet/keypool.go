@@ -0,0 +1,109 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/copernet/copernicus/crypto"
+	"github.com/copernet/copernicus/log"
+)
+
+// defaultKeyPoolTargetSize is how many unused external-chain keys copernicus
+// tries to keep pre-derived and ready to hand out, so GetReservedKey only
+// blocks on derivation or DB writes when the pool has run empty.
+const defaultKeyPoolTargetSize = 100
+
+var bucketHDIndices = []byte("hdindices")
+
+// keyPoolEntry is a pre-derived, not-yet-handed-out external chain key.
+type keyPoolEntry struct {
+	index  uint32
+	pubKey *crypto.PublicKey
+}
+
+func (w *Wallet) startKeyPool() {
+	w.keyPool = make([]*keyPoolEntry, 0, defaultKeyPoolTargetSize)
+	w.topUpKeyPool()
+
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			w.topUpKeyPool()
+		}
+	}()
+}
+
+// topUpKeyPool derives and stores enough fresh external-chain keys to bring
+// the pool back up to defaultKeyPoolTargetSize. It is safe to call
+// concurrently with GetReservedKey.
+func (w *Wallet) topUpKeyPool() {
+	w.keyPoolLock.Lock()
+	defer w.keyPoolLock.Unlock()
+
+	w.accountLock.RLock()
+	acct, ok := w.hdAccounts[w.activeAccount]
+	w.accountLock.RUnlock()
+	if !ok {
+		return
+	}
+
+	for len(w.keyPool) < defaultKeyPoolTargetSize {
+		index, pubKey, err := w.deriveNextExternalKey(acct)
+		if err != nil {
+			log.Error("topUpKeyPool derive/save index fail. error:%s", err.Error())
+			return
+		}
+		w.keyPool = append(w.keyPool, &keyPoolEntry{index: index, pubKey: pubKey})
+	}
+}
+
+// GetReservedKey hands out the next pre-derived key from the keypool,
+// topping the pool back up in the background once it drops below half its
+// target size. If the pool is empty, it tops it up synchronously first.
+func (w *Wallet) GetReservedKey() (*crypto.PublicKey, error) {
+	w.keyPoolLock.Lock()
+	if len(w.keyPool) == 0 {
+		w.keyPoolLock.Unlock()
+		w.topUpKeyPool()
+		w.keyPoolLock.Lock()
+	}
+
+	if len(w.keyPool) == 0 {
+		w.keyPoolLock.Unlock()
+		// Keypool derivation failed (e.g. HD wallet not initialized); fall
+		// back to deriving a key synchronously so callers still get a key.
+		return w.GenerateNewKey()
+	}
+
+	entry := w.keyPool[0]
+	w.keyPool = w.keyPool[1:]
+	remaining := len(w.keyPool)
+	w.keyPoolLock.Unlock()
+
+	w.reservedKeys = append(w.reservedKeys, entry.pubKey)
+
+	if remaining < defaultKeyPoolTargetSize/2 {
+		go w.topUpKeyPool()
+	}
+	return entry.pubKey, nil
+}
+
+func hdIndexKey(accountName string) []byte {
+	return []byte(accountName)
+}
+
+func (w *Wallet) saveHDIndices(accountName string, nextExternal, nextInternal uint32) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint32(value[0:4], nextExternal)
+	binary.BigEndian.PutUint32(value[4:8], nextInternal)
+	return w.WalletDB.Put(bucketHDIndices, hdIndexKey(accountName), value)
+}
+
+func (w *Wallet) loadHDIndices(accountName string) (nextExternal, nextInternal uint32) {
+	value, err := w.WalletDB.Get(bucketHDIndices, hdIndexKey(accountName))
+	if err != nil || len(value) != 8 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint32(value[0:4]), binary.BigEndian.Uint32(value[4:8])
+}
@@ -0,0 +1,889 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/copernet/copernicus/crypto"
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/model/script"
+	"github.com/copernet/copernicus/model/tx"
+	"github.com/copernet/copernicus/model/txin"
+	"github.com/copernet/copernicus/model/txout"
+	"github.com/copernet/copernicus/model/utxo"
+	"github.com/copernet/copernicus/util"
+	"github.com/copernet/copernicus/util/amount"
+)
+
+// psbtMagic is the BIP174 file-format magic, written ahead of the
+// serialized unsigned transaction.
+var psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// BIP174 key types this wallet reads and writes. Unlisted key types (e.g.
+// PSBT_IN_POR_COMMITMENT) are passed over on read per the spec's
+// forward-compatibility rule: unknown keys are simply ignored.
+const (
+	psbtGlobalUnsignedTx = 0x00
+
+	psbtInNonWitnessUTXO  = 0x00
+	psbtInWitnessUTXO     = 0x01
+	psbtInPartialSig      = 0x02
+	psbtInSighashType     = 0x03
+	psbtInRedeemScript    = 0x04
+	psbtInBip32Derivation = 0x06
+	psbtInFinalScriptSig  = 0x07
+
+	psbtOutRedeemScript    = 0x00
+	psbtOutBip32Derivation = 0x02
+)
+
+// Bip32Derivation records which wallet key, at which BIP32 path, is needed
+// to satisfy an input or output script. It's what lets a watch-only wallet
+// hand a PSBT to an external signer (hardware wallet, cosigner) and have it
+// figure out which of its own keys to sign with.
+type Bip32Derivation struct {
+	MasterKeyFingerprint uint32
+	DerivationPath       []uint32
+	PubKey               *crypto.PublicKey
+}
+
+// PsbtInput mirrors the per-input fields of BIP174 that copernicus
+// populates and consumes.
+type PsbtInput struct {
+	NonWitnessUTXO *tx.Tx
+	WitnessUTXO    *txout.TxOut
+	RedeemScript   *script.Script
+	SighashType    uint32
+
+	// PartialSigs is keyed by the serialized compressed pubkey.
+	PartialSigs      map[string][]byte
+	Bip32Derivations []*Bip32Derivation
+
+	FinalScriptSig *script.Script
+}
+
+func (pi *PsbtInput) isFinalized() bool {
+	return pi.FinalScriptSig != nil
+}
+
+// PsbtOutput mirrors the per-output fields of BIP174.
+type PsbtOutput struct {
+	RedeemScript     *script.Script
+	Bip32Derivations []*Bip32Derivation
+}
+
+// Psbt is a Partially Signed Bitcoin Transaction as defined by BIP174: an
+// unsigned transaction plus, for every input/output, the extra data a
+// signer needs that isn't itself part of the transaction.
+type Psbt struct {
+	UnsignedTx *tx.Tx
+	Inputs     []*PsbtInput
+	Outputs    []*PsbtOutput
+}
+
+// Recipient is a single output requested of FundPSBT/CreateTransaction.
+type Recipient struct {
+	ScriptPubKey *script.Script
+	Value        amount.Amount
+}
+
+// FundPSBT builds an unsigned transaction paying recipients at feeRate,
+// selecting inputs from changeAccount's unspent coins, and wraps it as a
+// PSBT with every input's WitnessUTXO/NonWitnessUTXO, RedeemScript and
+// BIP32 derivation paths populated so both copernicus and an external
+// signer can complete it. changeAccount may be watch-only: funding still
+// succeeds, producing a PSBT with no partial signatures for an external
+// signer to complete.
+func (w *Wallet) FundPSBT(recipients []*Recipient, feeRate *util.FeeRate, changeAccount string) (*Psbt, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("wallet: FundPSBT requires at least one recipient")
+	}
+	w.accountLock.RLock()
+	_, ok := w.hdAccounts[changeAccount]
+	w.accountLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("wallet: no such account %q", changeAccount)
+	}
+
+	selected, changeValue, err := w.selectCoinsForPSBT(recipients, feeRate, changeAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	unsignedTx := tx.NewTx(tx.DefaultVersion, 0)
+	for _, coin := range selected {
+		unsignedTx.AddIn(txin.NewTxIn(coin.outPoint, script.NewEmptyScript(), script.SequenceFinal))
+	}
+	for _, recipient := range recipients {
+		unsignedTx.AddOut(txout.NewTxOut(int64(recipient.Value), recipient.ScriptPubKey))
+	}
+	if changeValue > 0 {
+		changeKey, err := w.GetRawChangeKeyForAccount(changeAccount)
+		if err != nil {
+			return nil, err
+		}
+		changeScript := script.NewScriptRaw(script.NewP2PKHScript(util.Hash160(changeKey.ToBytes())))
+		unsignedTx.AddOut(txout.NewTxOut(int64(changeValue), changeScript))
+	}
+
+	psbt := &Psbt{
+		UnsignedTx: unsignedTx,
+		Inputs:     make([]*PsbtInput, len(selected)),
+		Outputs:    make([]*PsbtOutput, len(unsignedTx.GetOuts())),
+	}
+
+	for i, coin := range selected {
+		input, err := w.buildPsbtInput(coin)
+		if err != nil {
+			return nil, err
+		}
+		psbt.Inputs[i] = input
+	}
+	for i := range psbt.Outputs {
+		psbt.Outputs[i] = &PsbtOutput{}
+	}
+
+	return psbt, nil
+}
+
+// buildPsbtInput fills in everything a signer needs to sign the given coin:
+// its previous output (witness or non-witness form), the redeem script if
+// it's P2SH, and a BIP32 derivation entry for every wallet key that appears
+// in the scriptPubKey (or, for P2SH, the redeem script).
+func (w *Wallet) buildPsbtInput(coin *selectedCoin) (*PsbtInput, error) {
+	input := &PsbtInput{
+		SighashType: uint32(crypto.SigHashAll | crypto.SigHashForkID),
+		PartialSigs: make(map[string][]byte),
+	}
+
+	txOut := txout.NewTxOut(int64(coin.coin.GetAmount()), coin.coin.GetScriptPubKey())
+	input.WitnessUTXO = txOut
+
+	if prevTx := w.lookupPrevTx(coin.outPoint.Hash); prevTx != nil {
+		input.NonWitnessUTXO = prevTx
+	}
+
+	pkScript := coin.coin.GetScriptPubKey()
+	signingScript := pkScript
+	pubKeyType, pubKeys, isStandard := pkScript.IsStandardScriptPubKey()
+	if isStandard && pubKeyType == script.ScriptHash {
+		redeemScript := w.GetScript(pubKeys[0])
+		if redeemScript == nil {
+			return nil, fmt.Errorf("wallet: missing redeem script for %x", pubKeys[0])
+		}
+		input.RedeemScript = redeemScript
+		signingScript = redeemScript
+	}
+
+	for _, derivation := range w.deriveBip32Derivations(signingScript) {
+		input.Bip32Derivations = append(input.Bip32Derivations, derivation)
+	}
+
+	return input, nil
+}
+
+// deriveBip32Derivations returns a Bip32Derivation entry for every key in
+// signingScript that this wallet has derived from its HD hierarchy.
+func (w *Wallet) deriveBip32Derivations(signingScript *script.Script) []*Bip32Derivation {
+	var derivations []*Bip32Derivation
+
+	pubKeyType, pubKeys, isStandard := signingScript.IsStandardScriptPubKey()
+	if !isStandard {
+		return derivations
+	}
+
+	for _, candidate := range pubKeys {
+		// A P2PKH script already carries the pubkey hash; every other
+		// standard type carries the pubkey itself.
+		pubKeyHash := candidate
+		if pubKeyType != script.ScriptPubkeyHash {
+			pubKeyHash = util.Hash160(candidate)
+		}
+
+		pubKey := w.watchedPubKey(pubKeyHash)
+		if keyPair := w.GetKeyPair(pubKeyHash); keyPair != nil {
+			pubKey = keyPair.PubKey()
+		}
+		if pubKey == nil {
+			continue
+		}
+
+		path, fingerprint, ok := w.lookupDerivationPath(pubKey)
+		if !ok {
+			continue
+		}
+		derivations = append(derivations, &Bip32Derivation{
+			MasterKeyFingerprint: fingerprint,
+			DerivationPath:       path,
+			PubKey:               pubKey,
+		})
+	}
+	return derivations
+}
+
+// masterKeyFingerprint is BIP32's key fingerprint for the wallet's master
+// key: the first 4 bytes of HASH160(pubkey). It must not be confused with
+// ParentFingerprint, which BIP32 defines as always 0x00000000 for a master
+// key (it has no parent) and so can't identify which wallet a
+// Bip32Derivation belongs to.
+func (w *Wallet) masterKeyFingerprint() uint32 {
+	if w.hdMaster == nil {
+		return 0
+	}
+	neutered, err := w.hdMaster.Neuter()
+	if err != nil {
+		return 0
+	}
+	pubKey, err := neutered.ECPubKey()
+	if err != nil {
+		return 0
+	}
+	fingerprint := util.Hash160(pubKey.SerializeCompressed())[:4]
+	return binary.LittleEndian.Uint32(fingerprint)
+}
+
+// SignPSBT adds this wallet's partial signatures to every input it controls
+// a key for. It is idempotent: inputs that are already finalized, or that
+// already carry our signature, are left untouched so SignPSBT can safely be
+// called again after an external signer adds its own signatures.
+func (w *Wallet) SignPSBT(psbt *Psbt) error {
+	for i, input := range psbt.Inputs {
+		if input.isFinalized() {
+			continue
+		}
+
+		for _, derivation := range input.Bip32Derivations {
+			pubKeyBytes := derivation.PubKey.ToBytes()
+			if _, signed := input.PartialSigs[string(pubKeyBytes)]; signed {
+				continue
+			}
+
+			keyPair := w.GetKeyPair(util.Hash160(pubKeyBytes))
+			if keyPair == nil {
+				continue // not our key; left for an external signer
+			}
+
+			sigHash, err := psbt.computeSigHash(i, input)
+			if err != nil {
+				return err
+			}
+			sig, err := keyPair.Sign(sigHash)
+			if err != nil {
+				return err
+			}
+			input.PartialSigs[string(pubKeyBytes)] = append(sig, byte(input.SighashType))
+		}
+	}
+	return nil
+}
+
+// computeSigHash computes the BIP143-style sighash (copernicus signs with
+// SigHashForkID per input.SighashType) for input i against its previous
+// output / redeem script.
+func (psbt *Psbt) computeSigHash(inputIndex int, input *PsbtInput) (*util.Hash, error) {
+	scriptCode := input.RedeemScript
+	if scriptCode == nil {
+		scriptCode = input.WitnessUTXO.GetScriptPubKey()
+	}
+	return tx.SignatureHash(psbt.UnsignedTx, scriptCode, uint32(input.SighashType), inputIndex,
+		input.WitnessUTXO.GetValue())
+}
+
+// FinalizePSBT assembles the final scriptSig for every signed input from
+// its partial signatures and redeem script, producing a standalone,
+// broadcastable transaction. The finished tx is stamped back into
+// walletTxns the same way AddToWallet does for transactions we build
+// ourselves.
+func (w *Wallet) FinalizePSBT(psbt *Psbt) (*tx.Tx, error) {
+	finalTx := psbt.UnsignedTx.Clone()
+
+	for i, input := range psbt.Inputs {
+		if !input.isFinalized() {
+			finalScript, err := finalizeInputScript(input)
+			if err != nil {
+				return nil, fmt.Errorf("wallet: finalize input %d: %w", i, err)
+			}
+			input.FinalScriptSig = finalScript
+		}
+		finalTx.GetIns()[i].SetScriptSig(input.FinalScriptSig)
+	}
+
+	if err := w.AddToWallet(finalTx, map[string]string{"psbt": "finalized"}); err != nil {
+		log.Error("FinalizePSBT save to wallet fail. error:%s", err.Error())
+		return nil, err
+	}
+	return finalTx, nil
+}
+
+// finalizeInputScript builds scriptSig = [sig ...] [redeemScript] (P2SH),
+// scriptSig = [sig] [pubkey] (P2PKH) or scriptSig = [sig] (P2PK), ordering
+// partial sigs per their pubkey's position in the redeem script for
+// multisig.
+func finalizeInputScript(input *PsbtInput) (*script.Script, error) {
+	if len(input.PartialSigs) == 0 {
+		return nil, errors.New("no partial signatures to finalize")
+	}
+
+	var buf bytes.Buffer
+	if input.RedeemScript != nil {
+		scriptType, pubKeys, _ := input.RedeemScript.IsStandardScriptPubKey()
+		if scriptType == script.ScriptMultiSig {
+			// OP_CHECKMULTISIG pops one extra stack element it never
+			// uses, a well-known off-by-one in the original
+			// implementation; every scriptSig that satisfies a multisig
+			// redeem script has to push a dummy element ahead of the
+			// signatures to account for it.
+			pushScriptData(&buf, nil)
+		}
+		for _, pubKey := range pubKeys {
+			if sig, ok := input.PartialSigs[string(pubKey)]; ok {
+				pushScriptData(&buf, sig)
+			}
+		}
+		pushScriptData(&buf, input.RedeemScript.GetData())
+	} else {
+		p2pkh := false
+		if input.WitnessUTXO != nil {
+			pkType, _, _ := input.WitnessUTXO.GetScriptPubKey().IsStandardScriptPubKey()
+			p2pkh = pkType == script.ScriptPubkeyHash
+		}
+		for pubKey, sig := range input.PartialSigs {
+			pushScriptData(&buf, sig)
+			if p2pkh {
+				pushScriptData(&buf, []byte(pubKey))
+			}
+		}
+	}
+
+	return script.NewScriptRaw(buf.Bytes()), nil
+}
+
+func pushScriptData(buf *bytes.Buffer, data []byte) {
+	builder := script.NewEmptyScript()
+	builder.PushSingleData(data)
+	buf.Write(builder.GetData())
+}
+
+// lookupPrevTx retrieves the full previous transaction for NonWitnessUTXO
+// from the wallet's own history, falling back to nil (WitnessUTXO alone is
+// sufficient for signing, NonWitnessUTXO is only required by some hardware
+// signers for legacy inputs).
+func (w *Wallet) lookupPrevTx(hash util.Hash) *tx.Tx {
+	w.txnLock.RLock()
+	defer w.txnLock.RUnlock()
+	if wtx, ok := w.walletTxns[hash]; ok {
+		return wtx.Tx
+	}
+	return nil
+}
+
+// lookupDerivationPath returns the BIP44 path a wallet-controlled pubkey
+// was derived at, and the master key fingerprint that path is relative
+// to, so both can be attached to a PSBT input/output as a Bip32Derivation.
+// Returns ok=false for imported (non-HD) keys.
+func (w *Wallet) lookupDerivationPath(pubKey *crypto.PublicKey) ([]uint32, uint32, bool) {
+	w.accountLock.RLock()
+	defer w.accountLock.RUnlock()
+	for _, acct := range w.hdAccounts {
+		if path, fingerprint, ok := w.searchAccountChain(acct, externalChain, pubKey); ok {
+			return path, fingerprint, true
+		}
+		if path, fingerprint, ok := w.searchAccountChain(acct, internalChain, pubKey); ok {
+			return path, fingerprint, true
+		}
+	}
+	return nil, 0, false
+}
+
+func (w *Wallet) searchAccountChain(acct *hdAccount, chain uint32, pubKey *crypto.PublicKey) ([]uint32, uint32, bool) {
+	limit := acct.nextExternalIndex
+	if chain == internalChain {
+		limit = acct.nextInternalIndex
+	}
+
+	target := pubKey.ToBytes()
+	for index := uint32(0); index < limit; index++ {
+		parent := acct.external
+		if chain == internalChain {
+			parent = acct.internal
+		}
+		child, err := parent.Child(index)
+		if err != nil {
+			continue
+		}
+		ecPub, err := child.ECPubKey()
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(ecPub.SerializeCompressed(), target) {
+			path, fingerprint := w.derivationOrigin(acct, chain, index)
+			return path, fingerprint, true
+		}
+	}
+	return nil, 0, false
+}
+
+// derivationOrigin returns the (path, master fingerprint) pair a
+// Bip32Derivation should carry for the key at chain/index within acct.
+//
+// For a spending account, that's this wallet's own masterKeyFingerprint
+// and the full BIP44 path this wallet actually derived the key at - acct
+// is rooted in our own seed, so every component of the path is real.
+//
+// For a watch-only account, acct.external/internal are rooted in an
+// *externally* owned xpub: attaching our own masterKeyFingerprint would
+// point a hardware signer at the wrong wallet, and fabricating
+// purpose'/coin-type'/account' components ImportXPub was never given
+// would point it at the wrong key within the right wallet. So a
+// watch-only account instead carries whatever origin fingerprint/path
+// ImportXPub was actually given (acct.originPath is nil if it wasn't),
+// with only chain/index appended, since those two components this wallet
+// did derive itself from the imported xpub.
+func (w *Wallet) derivationOrigin(acct *hdAccount, chain, index uint32) ([]uint32, uint32) {
+	if acct.watchOnly {
+		path := append(append([]uint32{}, acct.originPath...), chain, index)
+		return path, acct.originFingerprint
+	}
+	return []uint32{
+		hdkeychain.HardenedKeyStart + hdPurpose,
+		hdkeychain.HardenedKeyStart + hdCoinType,
+		hdkeychain.HardenedKeyStart + acct.bip44Index,
+		chain, index,
+	}, w.masterKeyFingerprint()
+}
+
+// selectedCoin pairs a UTXO with the outpoint it lives at, which is all
+// FundPSBT needs to build an input.
+type selectedCoin struct {
+	outPoint *outpoint.OutPoint
+	coin     *utxo.Coin
+}
+
+// selectCoinsForPSBT picks enough of changeAccount's trusted, unlocked coins
+// to cover recipients plus fees, taking them in walletTxns map iteration
+// order, which is unspecified. PSBT funding keeps this simple first-fit
+// selector rather than coinselect's BnB/knapsack engine (see
+// Wallet.CreateTransaction): a PSBT's inputs are often meant to be
+// reviewed or added to by an external signer before being broadcast.
+func (w *Wallet) selectCoinsForPSBT(recipients []*Recipient, feeRate *util.FeeRate, changeAccount string) ([]*selectedCoin, amount.Amount, error) {
+	target := amount.Amount(0)
+	for _, recipient := range recipients {
+		target += recipient.Value
+	}
+
+	w.accountLock.RLock()
+	acct := w.hdAccounts[changeAccount]
+	w.accountLock.RUnlock()
+
+	w.txnLock.RLock()
+	defer w.txnLock.RUnlock()
+
+	// feeFor estimates the fee of a transaction spending inputs coins to the
+	// recipients plus a change output.
+	feeFor := func(inputs int) amount.Amount {
+		return amount.Amount(feeRate.GetFee(148*inputs + 34*(len(recipients)+1) + 10))
+	}
+
+	var selected []*selectedCoin
+	total := amount.Amount(0)
+	for hash, wtx := range w.walletTxns {
+		if !w.IsTrusted(wtx) {
+			continue
+		}
+		for index := 0; index < wtx.Tx.GetOutsCount(); index++ {
+			out := outpoint.NewOutPoint(hash, uint32(index))
+			if _, locked := w.lockedCoins[*out]; locked {
+				continue
+			}
+			coin := wtx.GetUnspentCoin(index)
+			if coin == nil || coin.IsSpent() {
+				continue
+			}
+			if !w.scriptBelongsToAccount(coin.GetScriptPubKey(), acct) {
+				continue
+			}
+			selected = append(selected, &selectedCoin{outPoint: out, coin: coin})
+			total += amount.Amount(coin.GetAmount())
+			if total >= target+feeFor(len(selected)) {
+				break
+			}
+		}
+		if total >= target+feeFor(len(selected)) {
+			break
+		}
+	}
+
+	if total < target {
+		return nil, 0, errors.New("wallet: insufficient funds")
+	}
+
+	fee := feeFor(len(selected))
+	change := total - target - fee
+	if change < 0 {
+		return nil, 0, errors.New("wallet: insufficient funds to cover fee")
+	}
+
+	return selected, change, nil
+}
+
+// Serialize encodes the PSBT as an actual BIP174 binary: the file magic,
+// then the global map (just PSBT_GLOBAL_UNSIGNED_TX), then one key-value
+// map per input and per output, each terminated by a zero-length key. This
+// is the same byte layout any BIP174-compatible signer (hardware wallet
+// app, Specter, Electrum, bitcoin-cli) reads and writes.
+func (psbt *Psbt) Serialize() ([]byte, error) {
+	var body bytes.Buffer
+
+	var unsignedTx bytes.Buffer
+	if err := psbt.UnsignedTx.Serialize(&unsignedTx); err != nil {
+		return nil, err
+	}
+	writeKeyValue(&body, []byte{psbtGlobalUnsignedTx}, unsignedTx.Bytes())
+	body.WriteByte(0x00) // end of global map
+
+	for _, input := range psbt.Inputs {
+		if err := writePsbtInput(&body, input); err != nil {
+			return nil, err
+		}
+	}
+	for _, output := range psbt.Outputs {
+		writePsbtOutput(&body, output)
+	}
+
+	return append(append([]byte{}, psbtMagic...), body.Bytes()...), nil
+}
+
+// ParsePsbt decodes a PSBT previously produced by Serialize (or by any
+// other BIP174-compliant encoder).
+func ParsePsbt(data []byte) (*Psbt, error) {
+	if len(data) < len(psbtMagic) || !bytes.Equal(data[:len(psbtMagic)], psbtMagic) {
+		return nil, errors.New("wallet: invalid PSBT: bad magic")
+	}
+	r := bytes.NewReader(data[len(psbtMagic):])
+
+	psbt := &Psbt{}
+	sawUnsignedTx := false
+	for {
+		key, value, end, err := readKeyValue(r)
+		if err != nil {
+			return nil, fmt.Errorf("wallet: invalid PSBT: global map: %w", err)
+		}
+		if end {
+			break
+		}
+		if len(key) == 1 && key[0] == psbtGlobalUnsignedTx {
+			unsignedTx := tx.NewTx(tx.DefaultVersion, 0)
+			if err := unsignedTx.Unserialize(bytes.NewReader(value)); err != nil {
+				return nil, fmt.Errorf("wallet: invalid PSBT: unsigned tx: %w", err)
+			}
+			psbt.UnsignedTx = unsignedTx
+			sawUnsignedTx = true
+		}
+		// Any other global key is unknown to us and skipped, per BIP174.
+	}
+	if !sawUnsignedTx {
+		return nil, errors.New("wallet: invalid PSBT: missing unsigned transaction")
+	}
+
+	psbt.Inputs = make([]*PsbtInput, len(psbt.UnsignedTx.GetIns()))
+	for i := range psbt.Inputs {
+		input, err := readPsbtInput(r)
+		if err != nil {
+			return nil, fmt.Errorf("wallet: invalid PSBT: input %d: %w", i, err)
+		}
+		psbt.Inputs[i] = input
+	}
+
+	psbt.Outputs = make([]*PsbtOutput, len(psbt.UnsignedTx.GetOuts()))
+	for i := range psbt.Outputs {
+		output, err := readPsbtOutput(r)
+		if err != nil {
+			return nil, fmt.Errorf("wallet: invalid PSBT: output %d: %w", i, err)
+		}
+		psbt.Outputs[i] = output
+	}
+
+	return psbt, nil
+}
+
+func writePsbtInput(buf *bytes.Buffer, input *PsbtInput) error {
+	if input.NonWitnessUTXO != nil {
+		var prevTx bytes.Buffer
+		if err := input.NonWitnessUTXO.Serialize(&prevTx); err != nil {
+			return err
+		}
+		writeKeyValue(buf, []byte{psbtInNonWitnessUTXO}, prevTx.Bytes())
+	}
+	if input.WitnessUTXO != nil {
+		writeKeyValue(buf, []byte{psbtInWitnessUTXO}, serializeTxOut(input.WitnessUTXO))
+	}
+	for pubKey, sig := range input.PartialSigs {
+		writeKeyValue(buf, append([]byte{psbtInPartialSig}, []byte(pubKey)...), sig)
+	}
+	if input.SighashType != 0 {
+		var value [4]byte
+		binary.LittleEndian.PutUint32(value[:], input.SighashType)
+		writeKeyValue(buf, []byte{psbtInSighashType}, value[:])
+	}
+	if input.RedeemScript != nil {
+		writeKeyValue(buf, []byte{psbtInRedeemScript}, input.RedeemScript.GetData())
+	}
+	for _, derivation := range input.Bip32Derivations {
+		key := append([]byte{psbtInBip32Derivation}, derivation.PubKey.ToBytes()...)
+		writeKeyValue(buf, key, serializeBip32Derivation(derivation))
+	}
+	if input.FinalScriptSig != nil {
+		writeKeyValue(buf, []byte{psbtInFinalScriptSig}, input.FinalScriptSig.GetData())
+	}
+	buf.WriteByte(0x00) // end of this input's map
+	return nil
+}
+
+func readPsbtInput(r *bytes.Reader) (*PsbtInput, error) {
+	input := &PsbtInput{PartialSigs: make(map[string][]byte)}
+	for {
+		key, value, end, err := readKeyValue(r)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			break
+		}
+		if len(key) == 0 {
+			continue
+		}
+		switch key[0] {
+		case psbtInNonWitnessUTXO:
+			prevTx := tx.NewTx(tx.DefaultVersion, 0)
+			if err := prevTx.Unserialize(bytes.NewReader(value)); err != nil {
+				return nil, fmt.Errorf("non-witness utxo: %w", err)
+			}
+			input.NonWitnessUTXO = prevTx
+		case psbtInWitnessUTXO:
+			out, err := deserializeTxOut(value)
+			if err != nil {
+				return nil, fmt.Errorf("witness utxo: %w", err)
+			}
+			input.WitnessUTXO = out
+		case psbtInPartialSig:
+			if len(key) < 2 {
+				continue
+			}
+			input.PartialSigs[string(key[1:])] = append([]byte{}, value...)
+		case psbtInSighashType:
+			if len(value) != 4 {
+				return nil, errors.New("malformed sighash type")
+			}
+			input.SighashType = binary.LittleEndian.Uint32(value)
+		case psbtInRedeemScript:
+			input.RedeemScript = script.NewScriptRaw(append([]byte{}, value...))
+		case psbtInBip32Derivation:
+			if len(key) < 2 {
+				continue
+			}
+			derivation, err := deserializeBip32Derivation(key[1:], value)
+			if err != nil {
+				return nil, fmt.Errorf("bip32 derivation: %w", err)
+			}
+			input.Bip32Derivations = append(input.Bip32Derivations, derivation)
+		case psbtInFinalScriptSig:
+			input.FinalScriptSig = script.NewScriptRaw(append([]byte{}, value...))
+		}
+		// Any other input key is unknown to us and skipped, per BIP174.
+	}
+	return input, nil
+}
+
+func writePsbtOutput(buf *bytes.Buffer, output *PsbtOutput) {
+	if output.RedeemScript != nil {
+		writeKeyValue(buf, []byte{psbtOutRedeemScript}, output.RedeemScript.GetData())
+	}
+	for _, derivation := range output.Bip32Derivations {
+		key := append([]byte{psbtOutBip32Derivation}, derivation.PubKey.ToBytes()...)
+		writeKeyValue(buf, key, serializeBip32Derivation(derivation))
+	}
+	buf.WriteByte(0x00) // end of this output's map
+}
+
+func readPsbtOutput(r *bytes.Reader) (*PsbtOutput, error) {
+	output := &PsbtOutput{}
+	for {
+		key, value, end, err := readKeyValue(r)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			break
+		}
+		if len(key) == 0 {
+			continue
+		}
+		switch key[0] {
+		case psbtOutRedeemScript:
+			output.RedeemScript = script.NewScriptRaw(append([]byte{}, value...))
+		case psbtOutBip32Derivation:
+			if len(key) < 2 {
+				continue
+			}
+			derivation, err := deserializeBip32Derivation(key[1:], value)
+			if err != nil {
+				return nil, fmt.Errorf("bip32 derivation: %w", err)
+			}
+			output.Bip32Derivations = append(output.Bip32Derivations, derivation)
+		}
+		// Any other output key is unknown to us and skipped, per BIP174.
+	}
+	return output, nil
+}
+
+// serializeTxOut encodes out the same way BIP174's PSBT_IN_WITNESS_UTXO
+// value is defined: an 8-byte little-endian amount followed by a
+// CompactSize-prefixed scriptPubKey.
+func serializeTxOut(out *txout.TxOut) []byte {
+	var buf bytes.Buffer
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], uint64(out.GetValue()))
+	buf.Write(value[:])
+	pkScript := out.GetScriptPubKey().GetData()
+	writeCompactSize(&buf, uint64(len(pkScript)))
+	buf.Write(pkScript)
+	return buf.Bytes()
+}
+
+func deserializeTxOut(data []byte) (*txout.TxOut, error) {
+	r := bytes.NewReader(data)
+	var value [8]byte
+	if _, err := io.ReadFull(r, value[:]); err != nil {
+		return nil, errors.New("truncated txout")
+	}
+	scriptLen, err := readCompactSize(r)
+	if err != nil {
+		return nil, errors.New("truncated txout scriptPubKey length")
+	}
+	pkScript := make([]byte, scriptLen)
+	if _, err := io.ReadFull(r, pkScript); err != nil {
+		return nil, errors.New("truncated txout scriptPubKey")
+	}
+	amt := int64(binary.LittleEndian.Uint64(value[:]))
+	return txout.NewTxOut(amt, script.NewScriptRaw(pkScript)), nil
+}
+
+// serializeBip32Derivation encodes d the way BIP174 defines a
+// PSBT_IN/OUT_BIP32_DERIVATION value: a 4-byte master key fingerprint
+// followed by each derivation step as a 4-byte little-endian uint32.
+func serializeBip32Derivation(d *Bip32Derivation) []byte {
+	value := make([]byte, 4+4*len(d.DerivationPath))
+	binary.LittleEndian.PutUint32(value[0:4], d.MasterKeyFingerprint)
+	for i, step := range d.DerivationPath {
+		binary.LittleEndian.PutUint32(value[4+4*i:], step)
+	}
+	return value
+}
+
+func deserializeBip32Derivation(pubKeyBytes, value []byte) (*Bip32Derivation, error) {
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, errors.New("malformed bip32 derivation")
+	}
+	fingerprint := binary.LittleEndian.Uint32(value[0:4])
+	path := make([]uint32, (len(value)-4)/4)
+	for i := range path {
+		path[i] = binary.LittleEndian.Uint32(value[4+4*i:])
+	}
+	return &Bip32Derivation{
+		MasterKeyFingerprint: fingerprint,
+		DerivationPath:       path,
+		PubKey:               crypto.NewPublicKey(append([]byte{}, pubKeyBytes...)),
+	}, nil
+}
+
+// writeKeyValue writes one BIP174 map entry: a CompactSize-prefixed key
+// followed by a CompactSize-prefixed value.
+func writeKeyValue(buf *bytes.Buffer, key, value []byte) {
+	writeCompactSize(buf, uint64(len(key)))
+	buf.Write(key)
+	writeCompactSize(buf, uint64(len(value)))
+	buf.Write(value)
+}
+
+// readKeyValue reads one BIP174 map entry, reporting end=true (and no
+// key/value) on the zero-length key that terminates every map.
+func readKeyValue(r *bytes.Reader) (key, value []byte, end bool, err error) {
+	keyLen, err := readCompactSize(r)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if keyLen == 0 {
+		return nil, nil, true, nil
+	}
+	key = make([]byte, keyLen)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, nil, false, err
+	}
+	valueLen, err := readCompactSize(r)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	value = make([]byte, valueLen)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, nil, false, err
+	}
+	return key, value, false, nil
+}
+
+// writeCompactSize/readCompactSize implement Bitcoin's CompactSize varint,
+// the length prefix BIP174 uses for every key and value.
+func writeCompactSize(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xfd)
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	case n <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(0xff)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], n)
+		buf.Write(b[:])
+	}
+}
+
+func readCompactSize(r *bytes.Reader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch first {
+	case 0xfd:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint16(b[:])), nil
+	case 0xfe:
+		var b [4]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint32(b[:])), nil
+	case 0xff:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint64(b[:]), nil
+	default:
+		return uint64(first), nil
+	}
+}
@@ -0,0 +1,124 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/copernet/copernicus/crypto"
+	"github.com/copernet/copernicus/model/outpoint"
+	"github.com/copernet/copernicus/model/script"
+	"github.com/copernet/copernicus/model/tx"
+	"github.com/copernet/copernicus/model/txin"
+	"github.com/copernet/copernicus/model/txout"
+	"github.com/copernet/copernicus/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func dummyPubKey(b byte) *crypto.PublicKey {
+	compressed := make([]byte, 33)
+	compressed[0] = 0x02
+	compressed[32] = b
+	return crypto.NewPublicKey(compressed)
+}
+
+// buildTestPsbt assembles a single-input, single-output PSBT whose input
+// carries a witness UTXO, a P2SH redeem script, a sighash type, a partial
+// signature, and a BIP32 derivation entry for Serialize/ParsePsbt to
+// round-trip.
+func buildTestPsbt() *Psbt {
+	unsignedTx := tx.NewTx(tx.DefaultVersion, 0)
+	prevOut := outpoint.NewOutPoint(util.Hash{0x01}, 0)
+	unsignedTx.AddIn(txin.NewTxIn(prevOut, script.NewEmptyScript(), script.SequenceFinal))
+	unsignedTx.AddOut(txout.NewTxOut(50000, script.NewScriptRaw([]byte{0x76, 0xa9, 0x14})))
+
+	redeemScript := script.NewScriptRaw([]byte{0x51, 0x52})
+	pubKey := dummyPubKey(0x07)
+
+	return &Psbt{
+		UnsignedTx: unsignedTx,
+		Inputs: []*PsbtInput{
+			{
+				WitnessUTXO:  txout.NewTxOut(100000, script.NewScriptRaw([]byte{0xa9, 0x14})),
+				RedeemScript: redeemScript,
+				SighashType:  uint32(crypto.SigHashAll | crypto.SigHashForkID),
+				PartialSigs: map[string][]byte{
+					string(pubKey.ToBytes()): {0x30, 0x44, 0x01, 0x02},
+				},
+				Bip32Derivations: []*Bip32Derivation{
+					{MasterKeyFingerprint: 0xdeadbeef, DerivationPath: []uint32{44, 145, 0, 0, 3}, PubKey: pubKey},
+				},
+			},
+		},
+		Outputs: []*PsbtOutput{{}},
+	}
+}
+
+func TestPsbtSerializeParseRoundTrip(t *testing.T) {
+	original := buildTestPsbt()
+
+	data, err := original.Serialize()
+	assert.NoError(t, err)
+	assert.True(t, len(data) > len(psbtMagic))
+
+	parsed, err := ParsePsbt(data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, original.UnsignedTx.GetHash(), parsed.UnsignedTx.GetHash())
+	assert.Len(t, parsed.Inputs, 1)
+
+	in := parsed.Inputs[0]
+	assert.Equal(t, original.Inputs[0].WitnessUTXO.GetValue(), in.WitnessUTXO.GetValue())
+	assert.Equal(t, original.Inputs[0].RedeemScript.GetData(), in.RedeemScript.GetData())
+	assert.Equal(t, original.Inputs[0].SighashType, in.SighashType)
+	assert.Equal(t, original.Inputs[0].PartialSigs, in.PartialSigs)
+
+	assert.Len(t, in.Bip32Derivations, 1)
+	assert.Equal(t, uint32(0xdeadbeef), in.Bip32Derivations[0].MasterKeyFingerprint)
+	assert.Equal(t, []uint32{44, 145, 0, 0, 3}, in.Bip32Derivations[0].DerivationPath)
+	assert.Equal(t, original.Inputs[0].Bip32Derivations[0].PubKey.ToBytes(), in.Bip32Derivations[0].PubKey.ToBytes())
+}
+
+func TestPsbtParseRejectsBadMagic(t *testing.T) {
+	_, err := ParsePsbt([]byte("not a psbt"))
+	assert.Error(t, err)
+}
+
+func TestDerivationOriginWatchOnlyUsesImportedFingerprint(t *testing.T) {
+	acct := &hdAccount{
+		name:              "external",
+		watchOnly:         true,
+		bip44Index:        0, // never set by ImportXPub; must not leak into the path
+		originFingerprint: 0xaabbccdd,
+		originPath:        []uint32{44, 145, 0},
+	}
+
+	var w Wallet
+	path, fingerprint := w.derivationOrigin(acct, externalChain, 3)
+
+	assert.Equal(t, uint32(0xaabbccdd), fingerprint)
+	assert.Equal(t, []uint32{44, 145, 0, externalChain, 3}, path)
+}
+
+func TestDerivationOriginWatchOnlyWithNoOriginMetadata(t *testing.T) {
+	acct := &hdAccount{name: "external", watchOnly: true}
+
+	var w Wallet
+	path, fingerprint := w.derivationOrigin(acct, internalChain, 7)
+
+	assert.Equal(t, uint32(0), fingerprint)
+	assert.Equal(t, []uint32{internalChain, 7}, path)
+}
+
+func TestDerivationOriginSpendingUsesWalletMaster(t *testing.T) {
+	acct := &hdAccount{name: "default", bip44Index: 2}
+
+	var w Wallet
+	path, _ := w.derivationOrigin(acct, externalChain, 5)
+
+	assert.Equal(t, []uint32{
+		hdkeychain.HardenedKeyStart + hdPurpose,
+		hdkeychain.HardenedKeyStart + hdCoinType,
+		hdkeychain.HardenedKeyStart + 2,
+		externalChain, 5,
+	}, path)
+}
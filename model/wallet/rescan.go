@@ -0,0 +1,64 @@
+package wallet
+
+import (
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/model/chain"
+	"github.com/copernet/copernicus/model/tx"
+)
+
+// RescanFromHeight walks the main chain from startHeight to the tip,
+// re-adding every transaction that touches one of our scripts to
+// walletTxns. It is used after restoring a wallet from a seed/mnemonic,
+// where the keypool has been re-derived but walletTxns starts out empty.
+func (w *Wallet) RescanFromHeight(startHeight int32) error {
+	tip := chain.GetInstance().Tip()
+	if tip == nil {
+		return nil
+	}
+
+	scanned := 0
+	for height := startHeight; height <= tip.Height; height++ {
+		blockIndex := chain.GetInstance().GetIndex(height)
+		if blockIndex == nil {
+			continue
+		}
+		block, err := chain.GetInstance().ReadBlockFromDisk(blockIndex)
+		if err != nil {
+			log.Error("RescanFromHeight read block at height %d fail. error:%s", height, err.Error())
+			return err
+		}
+
+		for _, txn := range block.Txs {
+			if w.isRelevantToWallet(txn) {
+				if err := w.AddToWallet(txn, nil); err != nil {
+					return err
+				}
+			}
+			for _, in := range txn.GetIns() {
+				if w.GetUnspentCoin(in.PreviousOutPoint) != nil {
+					w.MarkSpent(in.PreviousOutPoint)
+				}
+			}
+		}
+		scanned++
+	}
+
+	log.Info("rescan complete: scanned %d blocks from height %d", scanned, startHeight)
+	return nil
+}
+
+// isRelevantToWallet reports whether a transaction pays to, or spends from,
+// a script this wallet controls or watches.
+func (w *Wallet) isRelevantToWallet(txn *tx.Tx) bool {
+	for _, out := range txn.GetOuts() {
+		if IsUnlockable(out.GetScriptPubKey()) || IsWatched(out.GetScriptPubKey()) {
+			return true
+		}
+	}
+	for _, in := range txn.GetIns() {
+		if w.GetUnspentCoin(in.PreviousOutPoint) != nil {
+			return true
+		}
+	}
+	return false
+}
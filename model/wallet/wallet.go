@@ -3,10 +3,9 @@
 package wallet
 
 import (
-	"crypto/rand"
-	"io"
 	"sync"
 
+	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/copernet/copernicus/conf"
 	"github.com/copernet/copernicus/crypto"
 	"github.com/copernet/copernicus/log"
@@ -30,6 +29,30 @@ type Wallet struct {
 	lockedCoins map[outpoint.OutPoint]struct{}
 	payTxFee    *util.FeeRate
 
+	// longTermFeeRate is coin selection's estimate of what it will cost to
+	// spend a UTXO further down the line, used to drop inputs that aren't
+	// worth adding even though they help meet today's target. Overridden
+	// with SetLongTermFeeRate; defaults to defaultLongTermFeeRate.
+	longTermFeeRate *util.FeeRate
+
+	// HD wallet state: hdMaster is the BIP32 master key derived from the
+	// wallet's seed, hdAccounts holds every opened account (spending or
+	// watch-only) by name, and activeAccount selects which one
+	// GenerateNewKey / GetReservedKey / GetRawChangeKey operate against.
+	hdMaster      *hdkeychain.ExtendedKey
+	hdAccounts    map[string]*hdAccount
+	accountLock   sync.RWMutex
+	activeAccount string
+
+	keyPool     []*keyPoolEntry
+	keyPoolLock sync.Mutex
+
+	// watchedKeys maps a pubkey hash to the watch-only key (and the
+	// account that imported it), so IsWatched can recognize scripts the
+	// wallet tracks but holds no signing key for, and PSBT construction
+	// can still attach a Bip32Derivation for it.
+	watchedKeys map[string]*watchedKey
+
 	crypto.KeyStore
 	ScriptStore
 	AddressBook
@@ -45,6 +68,11 @@ var globalWallet *Wallet
  */
 var fallbackFee = util.NewFeeRate(20000)
 
+// defaultLongTermFeeRate seeds longTermFeeRate when -walletlongtermfeerate
+// isn't set, until SetLongTermFeeRate is called with a better estimate
+// (e.g. from a fee estimator).
+const defaultLongTermFeeRate = 10000
+
 func InitWallet() {
 	defer func() {
 		if globalWallet == nil {
@@ -56,19 +84,38 @@ func InitWallet() {
 		return
 	}
 
+	longTermFeeRate := int64(defaultLongTermFeeRate)
+	if conf.Cfg.Wallet.LongTermFeeRate > 0 {
+		longTermFeeRate = conf.Cfg.Wallet.LongTermFeeRate
+	}
+
 	walletInstance := &Wallet{
-		enable:      true,
-		broadcastTx: conf.Cfg.Wallet.Broadcast,
-		txnLock:     new(sync.RWMutex),
-		walletTxns:  make(map[util.Hash]*WalletTx),
-		lockedCoins: make(map[outpoint.OutPoint]struct{}),
-		payTxFee:    util.NewFeeRate(0),
+		enable:          true,
+		broadcastTx:     conf.Cfg.Wallet.Broadcast,
+		txnLock:         new(sync.RWMutex),
+		walletTxns:      make(map[util.Hash]*WalletTx),
+		lockedCoins:     make(map[outpoint.OutPoint]struct{}),
+		payTxFee:        util.NewFeeRate(0),
+		longTermFeeRate: util.NewFeeRate(longTermFeeRate),
+		hdAccounts:      make(map[string]*hdAccount),
 	}
 
 	if err := walletInstance.Init(); err != nil {
 		return
 	}
 
+	walletInstance.loadWatchedKeys()
+
+	if err := walletInstance.initHDWallet(); err != nil {
+		log.Error("init HD wallet fail. error:%s", err.Error())
+		return
+	}
+	if err := walletInstance.loadAccounts(); err != nil {
+		log.Error("load wallet accounts fail. error:%s", err.Error())
+		return
+	}
+	walletInstance.startKeyPool()
+
 	globalWallet = walletInstance
 }
 
@@ -129,29 +176,9 @@ func (w *Wallet) loadFromDB() error {
 	return nil
 }
 
-func (w *Wallet) GenerateNewKey() (*crypto.PublicKey, error) {
-	secret := make([]byte, 32)
-	io.ReadFull(rand.Reader, secret)
-	privateKey := crypto.NewPrivateKeyFromBytes(secret, true)
-	w.AddKey(privateKey)
-	err := w.saveSecret(secret)
-	if err != nil {
-		log.Error("GenerateNewKey save to db fail. error:%s", err.Error())
-		return nil, err
-	}
-	return privateKey.PubKey(), nil
-}
-
-func (w *Wallet) GetReservedKey() (*crypto.PublicKey, error) {
-	// wallet function is only for testing. The keypool is not supported yet.
-	// generate new key each time
-	reservedKey, err := w.GenerateNewKey()
-	if err != nil {
-		return nil, err
-	}
-	w.reservedKeys = append(w.reservedKeys, reservedKey)
-	return reservedKey, nil
-}
+// GenerateNewKey and GetReservedKey now live in hdwallet.go / keypool.go,
+// deriving from the wallet's BIP32/BIP44 hierarchy instead of generating a
+// random secret per call.
 
 func (w *Wallet) AddScript(s *script.Script) error {
 	w.ScriptStore.AddScript(s)
@@ -271,6 +298,16 @@ func (w *Wallet) SetFeeRate(feePaid int64, byteSize int64) {
 	w.payTxFee = util.NewFeeRateWithSize(feePaid, byteSize)
 }
 
+// SetLongTermFeeRate overrides coin selection's estimate of future spending
+// cost, used to decide whether a UTXO is worth adding at all.
+func (w *Wallet) SetLongTermFeeRate(feeRate *util.FeeRate) {
+	w.longTermFeeRate = feeRate
+}
+
+func (w *Wallet) GetLongTermFeeRate() *util.FeeRate {
+	return w.longTermFeeRate
+}
+
 func (w *Wallet) GetMinimumFee(byteSize int) int64 {
 	feeNeeded := w.payTxFee.GetFee(byteSize)
 	// User didn't set tx fee
@@ -312,43 +349,74 @@ func (w *Wallet) MarkSpent(outPoint *outpoint.OutPoint) {
 	}
 }
 
-func IsUnlockable(scriptPubKey *script.Script) bool {
+// resolveSpendableScript unwraps a P2SH scriptPubKey to its redeem script
+// (when the wallet knows it) and returns the standard script type/pubkeys
+// that IsUnlockable/IsWatched actually test ownership of.
+func resolveSpendableScript(scriptPubKey *script.Script) (script.ScriptType, [][]byte, bool) {
 	if globalWallet == nil || scriptPubKey == nil {
-		return false
+		return script.ScriptNonStandard, nil, false
 	}
 
 	pubKeyType, pubKeys, isStandard := scriptPubKey.IsStandardScriptPubKey()
 	if !isStandard || pubKeyType == script.ScriptNonStandard || pubKeyType == script.ScriptNullData {
-		return false
+		return script.ScriptNonStandard, nil, false
 	}
 
 	if pubKeyType == script.ScriptHash {
 		redeemScript := globalWallet.GetScript(pubKeys[0])
 		if redeemScript == nil {
-			return false
+			return script.ScriptNonStandard, nil, false
 		}
 		pubKeyType, pubKeys, isStandard = redeemScript.IsStandardScriptPubKey()
 		if !isStandard || pubKeyType == script.ScriptNonStandard || pubKeyType == script.ScriptNullData {
-			return false
+			return script.ScriptNonStandard, nil, false
 		}
 	}
+	return pubKeyType, pubKeys, true
+}
 
-	if pubKeyType == script.ScriptPubkey {
-		pubKeyHash := util.Hash160(pubKeys[0])
+// IsUnlockable reports whether the wallet holds every private key needed to
+// spend scriptPubKey.
+func IsUnlockable(scriptPubKey *script.Script) bool {
+	return testOwnership(scriptPubKey, func(pubKeyHash []byte) bool {
 		return globalWallet.GetKeyPair(pubKeyHash) != nil
+	})
+}
+
+// IsWatched reports whether the wallet is tracking scriptPubKey via an
+// imported xpub or individual pubkey (a watch-only account), even though it
+// holds no private key for it. Watched transactions are tracked and
+// rescanned the same as owned ones, but IsWatched alone must never be used
+// to decide whether a signing path may proceed - that's IsUnlockable's job.
+func IsWatched(scriptPubKey *script.Script) bool {
+	return testOwnership(scriptPubKey, func(pubKeyHash []byte) bool {
+		return globalWallet.isWatchedPubKeyHash(pubKeyHash)
+	})
+}
+
+// testOwnership resolves scriptPubKey down to the pubkey hashes that must
+// satisfy owns, applying the same P2SH-unwrap and "all keys required for
+// multisig" rules to both IsUnlockable and IsWatched.
+func testOwnership(scriptPubKey *script.Script, owns func(pubKeyHash []byte) bool) bool {
+	pubKeyType, pubKeys, ok := resolveSpendableScript(scriptPubKey)
+	if !ok {
+		return false
+	}
+
+	switch pubKeyType {
+	case script.ScriptPubkey:
+		return owns(util.Hash160(pubKeys[0]))
 
-	} else if pubKeyType == script.ScriptPubkeyHash {
-		return globalWallet.GetKeyPair(pubKeys[0]) != nil
+	case script.ScriptPubkeyHash:
+		return owns(pubKeys[0])
 
-	} else if pubKeyType == script.ScriptMultiSig {
-		// Only consider transactions "mine" if we own ALL the keys
-		// involved. Multi-signature transactions that are partially owned
-		// (somebody else has a key that can spend them) enable
+	case script.ScriptMultiSig:
+		// Only consider transactions "ours" if we own (or watch) ALL the
+		// keys involved. Partially-owned multisig enables
 		// spend-out-from-under-you attacks, especially in shared-wallet
 		// situations.
 		for _, pubKey := range pubKeys[1:] {
-			pubKeyHash := util.Hash160(pubKey)
-			if globalWallet.GetKeyPair(pubKeyHash) == nil {
+			if !owns(util.Hash160(pubKey)) {
 				return false
 			}
 		}
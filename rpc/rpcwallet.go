@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"errors"
+
+	"github.com/copernet/copernicus/model/wallet"
+)
+
+// walletHandlers are the JSON-RPC methods backed by model/wallet's HD
+// keypool.
+var walletHandlers = map[string]commandHandler{
+	"getnewaddress":       handleGetNewAddress,
+	"getrawchangeaddress": handleGetRawChangeAddress,
+	"dumpwallet":          handleDumpWallet,
+}
+
+// init merges walletHandlers into the server's main command table
+// alongside the rest of the RPC surface, the same way rpcpsbt.go does for
+// psbtHandlers.
+func init() {
+	for method, handler := range walletHandlers {
+		rpcHandlers[method] = handler
+	}
+}
+
+func activeWallet() (*wallet.Wallet, error) {
+	w := wallet.GetInstance()
+	if w == nil || !w.IsEnable() {
+		return nil, errors.New("wallet is not enabled, restart with -wallet to enable it")
+	}
+	return w, nil
+}
+
+// handleGetNewAddress implements the getnewaddress RPC, returning the next
+// pre-derived keypool address for the active account.
+func handleGetNewAddress(s *Server, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := w.GetReservedKey()
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.ToAddress().String(), nil
+}
+
+// handleGetRawChangeAddress implements the getrawchangeaddress RPC, deriving
+// the next internal-chain (change) key instead of drawing from the external
+// keypool.
+func handleGetRawChangeAddress(s *Server, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := w.GetRawChangeKey()
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.ToAddress().String(), nil
+}
+
+// handleDumpWallet implements the dumpwallet RPC. Unlike upstream Bitcoin
+// Core, copernicus is HD-only, so dumping the wallet means exporting the
+// BIP39 mnemonic the whole keypool is derived from rather than a per-key
+// dump.
+func handleDumpWallet(s *Server, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+
+	mnemonic, err := w.DumpMnemonic()
+	if err != nil {
+		return nil, err
+	}
+	return mnemonic, nil
+}
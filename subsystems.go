@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/copernet/copernicus/log"
+	"github.com/copernet/copernicus/lifecycle"
+	"github.com/copernet/copernicus/model/tx"
+	"github.com/copernet/copernicus/model/wallet"
+	"github.com/copernet/copernicus/net/server"
+	"github.com/copernet/copernicus/rpc"
+	"github.com/copernet/copernicus/service"
+)
+
+// rebroadcastInterval is how often the txn-rebroadcaster resubmits the
+// wallet's unconfirmed transactions to peers.
+const rebroadcastInterval = 5 * time.Minute
+
+// serverSubsystem adapts *server.Server, already constructed by bchMain, to
+// lifecycle.Subsystem.
+type serverSubsystem struct {
+	srv   *server.Server
+	ready chan struct{}
+}
+
+func newServerSubsystem(srv *server.Server) *serverSubsystem {
+	return &serverSubsystem{srv: srv, ready: make(chan struct{})}
+}
+
+func (s *serverSubsystem) Name() string        { return "server" }
+func (s *serverSubsystem) DependsOn() []string { return []string{"msg-handle"} }
+
+func (s *serverSubsystem) Start(ctx context.Context) error {
+	s.srv.Start()
+	close(s.ready)
+	return nil
+}
+
+func (s *serverSubsystem) Ready() <-chan struct{} { return s.ready }
+
+func (s *serverSubsystem) Stop(ctx context.Context) error {
+	s.srv.Stop()
+	return nil
+}
+
+// rpcSubsystem adapts *rpc.Server to lifecycle.Subsystem. requestShutdown
+// is invoked the moment the RPC server asks for process shutdown (e.g. the
+// "stop" RPC command), so the runner tears everything else down the same
+// way an interrupt signal would.
+type rpcSubsystem struct {
+	srv             *rpc.Server
+	requestShutdown func()
+	ready           chan struct{}
+}
+
+func newRPCSubsystem(srv *rpc.Server, requestShutdown func()) *rpcSubsystem {
+	return &rpcSubsystem{srv: srv, requestShutdown: requestShutdown, ready: make(chan struct{})}
+}
+
+func (s *rpcSubsystem) Name() string        { return "rpc" }
+func (s *rpcSubsystem) DependsOn() []string { return nil }
+
+func (s *rpcSubsystem) Start(ctx context.Context) error {
+	s.srv.Start()
+	go func() {
+		select {
+		case <-s.srv.RequestedProcessShutdown():
+			s.requestShutdown()
+		case <-ctx.Done():
+		}
+	}()
+	close(s.ready)
+	return nil
+}
+
+func (s *rpcSubsystem) Ready() <-chan struct{} { return s.ready }
+
+func (s *rpcSubsystem) Stop(ctx context.Context) error {
+	s.srv.Stop()
+	return nil
+}
+
+// msgHandleSubsystem adapts service.NewMsgHandle to lifecycle.Subsystem. It
+// has to be ready before server starts consuming s.PhCh, and has nothing of
+// its own to tear down on Stop.
+type msgHandleSubsystem struct {
+	srv   *server.Server
+	ready chan struct{}
+}
+
+func newMsgHandleSubsystem(srv *server.Server) *msgHandleSubsystem {
+	return &msgHandleSubsystem{srv: srv, ready: make(chan struct{})}
+}
+
+func (m *msgHandleSubsystem) Name() string        { return "msg-handle" }
+func (m *msgHandleSubsystem) DependsOn() []string { return nil }
+
+func (m *msgHandleSubsystem) Start(ctx context.Context) error {
+	service.NewMsgHandle(ctx, m.srv.PhCh, m.srv)
+	close(m.ready)
+	return nil
+}
+
+func (m *msgHandleSubsystem) Ready() <-chan struct{} { return m.ready }
+
+func (m *msgHandleSubsystem) Stop(ctx context.Context) error { return nil }
+
+// walletSubsystem adapts wallet.InitWallet to lifecycle.Subsystem. A
+// disabled wallet (-wallet not set) still "starts" successfully; it's just
+// inert, matching wallet.InitWallet's own enable/disable handling.
+type walletSubsystem struct{}
+
+func (walletSubsystem) Name() string        { return "wallet" }
+func (walletSubsystem) DependsOn() []string { return nil }
+
+func (walletSubsystem) Start(ctx context.Context) error {
+	wallet.InitWallet()
+	return nil
+}
+
+func (walletSubsystem) Ready() <-chan struct{} { return lifecycle.ClosedReady() }
+func (walletSubsystem) Stop(ctx context.Context) error { return nil }
+
+// txBroadcaster is the one method the rebroadcaster needs from
+// *server.Server, declared locally so this file depends on a single method
+// rather than the whole server surface.
+type txBroadcaster interface {
+	BroadcastTx(txn *tx.Tx) error
+}
+
+// rebroadcastSubsystem periodically resubmits the wallet's unconfirmed
+// transactions to peers, replacing the old commented-out
+// s.rebroadcastHandler() stub.
+type rebroadcastSubsystem struct {
+	srv    txBroadcaster
+	ready  chan struct{}
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+func newRebroadcastSubsystem(srv txBroadcaster) *rebroadcastSubsystem {
+	return &rebroadcastSubsystem{srv: srv, ready: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (r *rebroadcastSubsystem) Name() string        { return "txn-rebroadcaster" }
+func (r *rebroadcastSubsystem) DependsOn() []string { return []string{"wallet", "server"} }
+
+// Start derives its own cancelable context from ctx rather than running
+// off ctx directly: Stop needs a way to end the run loop on its own, since
+// it can be called (by the runner's startup rollback) well before the
+// outer ctx passed to bchMain is ever canceled.
+func (r *rebroadcastSubsystem) Start(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	go r.run(runCtx)
+	close(r.ready)
+	return nil
+}
+
+func (r *rebroadcastSubsystem) Ready() <-chan struct{} { return r.ready }
+
+// Stop cancels run's context directly instead of relying on the outer ctx
+// bchMain holds, then waits for run to notice and exit, bounded by ctx's
+// own stop timeout.
+func (r *rebroadcastSubsystem) Stop(ctx context.Context) error {
+	r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *rebroadcastSubsystem) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(rebroadcastInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.rebroadcastUnconfirmed()
+		}
+	}
+}
+
+// rebroadcastUnconfirmed resubmits every wallet transaction that hasn't
+// appeared in a block yet, so a tx that a peer dropped doesn't just sit
+// unmined forever.
+func (r *rebroadcastSubsystem) rebroadcastUnconfirmed() {
+	w := wallet.GetInstance()
+	if w == nil || !w.IsEnable() || !w.GetBroadcastTx() {
+		return
+	}
+
+	for _, wtx := range w.GetWalletTxns() {
+		if wtx.GetDepthInMainChain() >= 1 {
+			continue // already mined; nothing left to rebroadcast
+		}
+		if err := r.srv.BroadcastTx(wtx.Tx); err != nil {
+			log.Error("txn-rebroadcaster: rebroadcast %s failed. error:%s", wtx.Tx.GetHash().String(), err.Error())
+		}
+	}
+}